@@ -1,250 +1,547 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
 	"math/rand"
-	"time"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+
+	pb "github.com/cheggaaa/pb/v3"
 
 	. "github.com/arata-nvm/nakitu/nakitu"
 )
 
-func main() {
-	rand.Seed(time.Now().UnixNano())
+const (
+	exitOK          = 0
+	exitFailure     = 1
+	exitUsage       = 2
+	exitScene       = 3
+	exitInterrupted = 130
+)
 
-	// image
-	aspectRatio := 16.0 / 9.0
-	imageWidth := 400
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("nakitu: ")
+	os.Exit(run(os.Args[1:]))
+}
 
-	// camera
-	lookFrom := NewVec3(13, 2, 3)
-	lookAt := NewVec3(0, 0, 0)
+func usage() {
+	fmt.Fprintf(os.Stderr, `usage: nakitu <command> [arguments]
 
-	vFOV := 20.0
-	vUp := NewVec3(0, 1, 0)
-	distToFocus := 10.0
-	aperture := 0.0
+commands:
+  render [flags] [scene]  render a built-in scene or a JSON scene file
+  scenes                  list the built-in scenes
+  help                    show this help
 
-	// scene
-	background := NewVec3(0.7, 0.8, 1.0)
-	samplesPerPixel := 100
-	maxDepth := 10
+run "nakitu render -h" for the render flags.
 
-	// world
-	var world Hittable
-	switch 4 {
-	case 0:
-		world = randomScene()
-	case 1:
-		world = earth()
-	case 2:
-		world = simpleLight()
-		background = NewVec3(0, 0, 0)
-		lookFrom = NewVec3(26, 3, 6)
-		lookAt = NewVec3(0, 2, 0)
-	case 3:
-		world = cornellBox()
-		aspectRatio = 1.0
-		imageWidth = 600
-		background = NewVec3(0, 0, 0)
-		lookFrom = NewVec3(278, 278, -800)
-		lookAt = NewVec3(278, 278, 0)
-		vFOV = 40.0
-	case 4:
-		world = finalScene()
-		aspectRatio = 1.0
-		imageWidth = 800
-		background = NewVec3(0, 0, 0)
-		lookFrom = NewVec3(478, 278, -600)
-		lookAt = NewVec3(278, 278, 0)
-		vFOV = 40.0
-	}
-
-	// camera
-	camera := NewCamera(
-		lookFrom,
-		lookAt,
-		vUp,
-		vFOV,
-		aspectRatio,
-		aperture,
-		distToFocus,
-	)
-	camera.Time1 = 1.0
-
-	// render
-	imageHeight := int(float64(float64(imageWidth)) / aspectRatio)
-	scene := NewScene(imageWidth, imageHeight, world, camera)
-	scene.SamplesPerPixel = samplesPerPixel
-	scene.MaxDepth = maxDepth
-	scene.Background = background
-	scene.RenderParallel(8)
-	scene.WriteToFile("image.ppm")
+exit status:
+  0    success
+  1    rendering or writing the output failed
+  2    invalid command line
+  3    the scene could not be loaded
+  130  interrupted; the partial image was written
+`)
 }
 
-func randomScene() Hittable {
-	world := NewHittableList()
-
-	checker := NewCheckerTexture(
-		NewSolidColor(0.2, 0.3, 0.1),
-		NewSolidColor(0.9, 0.9, 0.9),
-	)
-	matGround := NewLambertian(checker)
-	world.Add(NewSphere(NewVec3(0, -1000, 0), 1000, matGround))
-
-	for a := -11; a < 11; a++ {
-		for b := -11; b < 11; b++ {
-			r := rand.Float64()
-			center := NewVec3(
-				float64(a)+0.9*rand.Float64(),
-				0.2,
-				float64(b)+0.9*rand.Float64(),
-			)
-
-			if center.Sub(NewVec3(4, 0.2, 0)).Len() > 0.9 {
-				switch {
-				case r < 0.8:
-					albedo := RandomVec3().Mul(RandomVec3())
-					mat := NewLambertian(NewSolidColor(albedo.X(), albedo.Y(), albedo.Z()))
-					center2 := center.Add(NewVec3(0, Random(0, 0.5), 0))
-					world.Add(NewMovingSphere(center, center2, 0.0, 1.0, 0.2, mat))
-				case r < 0.95:
-					albedo := RandomVec3In(0.5, 1)
-					fuzz := Random(0, 0.5)
-					mat := NewMetal(albedo, fuzz)
-					world.Add(NewSphere(center, 0.2, mat))
-				default:
-					mat := NewDielectric(1.5)
-					world.Add(NewSphere(center, 0.2, mat))
-				}
+func run(args []string) int {
+	if len(args) == 0 {
+		usage()
+		return exitUsage
+	}
 
-			}
+	switch args[0] {
+	case "render":
+		return renderCommand(args[1:])
+	case "scenes":
+		for _, name := range builtinSceneNames() {
+			fmt.Println(name)
 		}
+		return exitOK
+	case "help", "-h", "-help", "--help":
+		usage()
+		return exitOK
 	}
 
-	mat1 := NewDielectric(1.5)
-	world.Add(NewSphere(NewVec3(0, 1, 0), 1, mat1))
+	log.Printf("unknown command %q", args[0])
+	usage()
+	return exitUsage
+}
 
-	mat2 := NewLambertian(NewSolidColor(0.4, 0.2, 0.1))
-	world.Add(NewSphere(NewVec3(-4, 1, 0), 1.0, mat2))
+type vec3Flag struct {
+	v Vec3
+}
 
-	mat3 := NewMetal(NewVec3(0.7, 0.6, 0.5), 0)
-	world.Add(NewSphere(NewVec3(4, 1, 0), 1.0, mat3))
+func (f *vec3Flag) String() string {
+	return fmt.Sprintf("%g,%g,%g", f.v[0], f.v[1], f.v[2])
+}
 
-	return world
+func (f *vec3Flag) Set(s string) error {
+	parts := strings.Split(s, ",")
+	if len(parts) != 3 {
+		return errors.New("want three comma-separated numbers, e.g. 13,2,3")
+	}
+	for i, part := range parts {
+		x, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil {
+			return fmt.Errorf("%q is not a number", part)
+		}
+		f.v[i] = x
+	}
+	return nil
 }
 
-func earth() Hittable {
-	world := NewHittableList()
+type renderOptions struct {
+	scene      string
+	width      int
+	height     int
+	spp        int
+	sppPass    int
+	depth      int
+	threads    int
+	seed       int64
+	output     string
+	format     string
+	checkpoint string
+	sampleMap  string
+	tileSize   int
+	tileOrder  string
+	toneMapper string
+	exposure   float64
+	adaptive   float64
+	minSpp     int
+	maxSpp     int
+	quiet      bool
+
+	lookFrom  vec3Flag
+	lookAt    vec3Flag
+	up        vec3Flag
+	vFOV      float64
+	aperture  float64
+	focusDist float64
+
+	set map[string]bool
+}
 
-	earthTexture := NewImageTexture("earthmap.jpg")
-	earthSurface := NewLambertian(earthTexture)
-	globe := NewSphere(NewVec3(0, 0, 0), 2, earthSurface)
-	world.Add(globe)
+func renderCommand(args []string) int {
+	var opts renderOptions
 
-	return world
-}
+	fs := flag.NewFlagSet("render", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: nakitu render [flags] [scene]\n\n")
+		fmt.Fprintf(fs.Output(), "scene is a JSON scene file or one of: %s (default final)\n\n", strings.Join(builtinSceneNames(), ", "))
+		fs.PrintDefaults()
+	}
 
-func simpleLight() Hittable {
-	world := NewHittableList()
+	fs.StringVar(&opts.scene, "scene", "final", "built-in scene name or JSON scene file")
+	fs.IntVar(&opts.width, "width", 0, "image width in pixels (default from the scene)")
+	fs.IntVar(&opts.height, "height", 0, "image height in pixels (default keeps the scene's aspect ratio)")
+	fs.IntVar(&opts.spp, "spp", 0, "samples per pixel (default from the scene)")
+	fs.IntVar(&opts.sppPass, "spp-pass", 0, "samples per pixel added by each progressive pass (default all at once, or -min-spp with -adaptive)")
+	fs.IntVar(&opts.depth, "depth", 0, "maximum path depth (default from the scene)")
+	fs.IntVar(&opts.threads, "threads", 0, "worker goroutines (default number of CPUs)")
+	fs.Int64Var(&opts.seed, "seed", 0, "random seed for scene construction and sampling")
+	fs.StringVar(&opts.output, "o", "image.ppm", "output file, or - for stdout")
+	fs.StringVar(&opts.format, "format", "", "output format: ppm, ppm-binary, png, jpeg, hdr or pfm (default from the file extension)")
+	fs.StringVar(&opts.checkpoint, "checkpoint", "", "checkpoint file to resume from and save after every pass")
+	fs.StringVar(&opts.sampleMap, "sample-map", "", "also write an image of the samples taken per pixel")
+	fs.IntVar(&opts.tileSize, "tile-size", 0, "tile edge length in pixels")
+	fs.StringVar(&opts.tileOrder, "tile-order", "", "tile order: scanline, spiral or hilbert")
+	fs.StringVar(&opts.toneMapper, "tonemap", "", "tone mapper: linear, reinhard, aces or uncharted2")
+	fs.Float64Var(&opts.exposure, "exposure", 0, "exposure adjustment in stops")
+	fs.Float64Var(&opts.adaptive, "adaptive", 0, "enable adaptive sampling with this relative error threshold")
+	fs.IntVar(&opts.minSpp, "min-spp", 16, "minimum samples per pixel with -adaptive")
+	fs.IntVar(&opts.maxSpp, "max-spp", 0, "maximum samples per pixel with -adaptive (default 4 x spp)")
+	fs.BoolVar(&opts.quiet, "quiet", false, "do not show a progress bar or texture memory")
+	fs.Var(&opts.lookFrom, "look-from", "camera position as x,y,z")
+	fs.Var(&opts.lookAt, "look-at", "camera target as x,y,z")
+	fs.Var(&opts.up, "up", "camera up vector as x,y,z")
+	fs.Float64Var(&opts.vFOV, "vfov", 0, "vertical field of view in degrees")
+	fs.Float64Var(&opts.aperture, "aperture", 0, "lens aperture")
+	fs.Float64Var(&opts.focusDist, "focus-dist", 0, "focus distance")
+
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return exitOK
+		}
+		return exitUsage
+	}
 
-	tex := NewCheckerTexture(
-		NewSolidColor(0.2, 0.3, 0.1),
-		NewSolidColor(0.9, 0.9, 0.9),
-	)
-	world.Add(NewSphere(NewVec3(0, -1000, 0), 1000, NewLambertian(tex)))
-	world.Add(NewSphere(NewVec3(0, 2, 0), 2, NewLambertian(tex)))
+	opts.set = map[string]bool{}
+	fs.Visit(func(f *flag.Flag) {
+		opts.set[f.Name] = true
+	})
 
-	difflight := NewDiffuseLight(NewSolidColor(4, 4, 4))
-	world.Add(NewXYRect(3, 1, 5, 3, -2, difflight))
+	switch fs.NArg() {
+	case 0:
+	case 1:
+		if opts.set["scene"] {
+			log.Print("render: give the scene either with -scene or as an argument, not both")
+			return exitUsage
+		}
+		opts.scene = fs.Arg(0)
+	default:
+		log.Printf("render: unexpected arguments %q", fs.Args()[1:])
+		return exitUsage
+	}
 
-	return world
-}
+	format, err := opts.validate()
+	if err != nil {
+		log.Printf("render: %v", err)
+		return exitUsage
+	}
 
-func cornellBox() Hittable {
-	world := NewHittableList()
+	scene, err := opts.loadScene()
+	if err != nil {
+		log.Print(err)
+		return exitScene
+	}
 
-	red := NewLambertian(NewSolidColor(0.65, 0.05, 0.05))
-	white := NewLambertian(NewSolidColor(0.73, 0.73, 0.73))
-	green := NewLambertian(NewSolidColor(0.12, 0.45, 0.15))
-	light := NewDiffuseLight(NewSolidColor(15, 15, 15))
+	if err := opts.apply(scene); err != nil {
+		log.Printf("render: %v", err)
+		return exitUsage
+	}
 
-	world.Add(NewYZRect(0, 0, 555, 555, 555, green))
-	world.Add(NewYZRect(0, 0, 555, 555, 0, red))
-	world.Add(NewXZRect(213, 227, 343, 332, 554, light))
-	world.Add(NewXZRect(0, 0, 555, 555, 0, white))
-	world.Add(NewXZRect(0, 0, 555, 555, 555, white))
-	world.Add(NewXYRect(0, 0, 555, 555, 555, white))
+	if !opts.quiet {
+		reportTextures(scene)
+	}
 
-	var box1 Hittable
-	box1 = NewBox(NewVec3(0, 0, 0), NewVec3(165, 330, 165), white)
-	box1 = NewRotateY(box1, 15)
-	box1 = NewTranslate(box1, NewVec3(265, 0, 295))
+	return opts.render(scene, format)
+}
+
+func (o *renderOptions) validate() (Format, error) {
+	positive := []struct {
+		name  string
+		value int
+	}{
+		{"spp", o.spp},
+		{"spp-pass", o.sppPass},
+		{"depth", o.depth},
+		{"threads", o.threads},
+		{"tile-size", o.tileSize},
+		{"min-spp", o.minSpp},
+		{"max-spp", o.maxSpp},
+	}
+	for _, flag := range positive {
+		if o.set[flag.name] && flag.value <= 0 {
+			return 0, fmt.Errorf("-%s must be positive, got %d", flag.name, flag.value)
+		}
+	}
+
+	if o.set["width"] && o.width < 2 {
+		return 0, fmt.Errorf("-width must be at least 2, got %d", o.width)
+	}
+	if o.set["height"] && o.height < 2 {
+		return 0, fmt.Errorf("-height must be at least 2, got %d", o.height)
+	}
+	if o.set["vfov"] && (o.vFOV <= 0 || o.vFOV >= 180) {
+		return 0, fmt.Errorf("-vfov must be between 0 and 180 degrees, got %g", o.vFOV)
+	}
+	if o.set["aperture"] && o.aperture < 0 {
+		return 0, fmt.Errorf("-aperture must not be negative, got %g", o.aperture)
+	}
+	if o.set["focus-dist"] && o.focusDist <= 0 {
+		return 0, fmt.Errorf("-focus-dist must be positive, got %g", o.focusDist)
+	}
+	if o.set["adaptive"] && o.adaptive <= 0 {
+		return 0, fmt.Errorf("-adaptive must be positive, got %g", o.adaptive)
+	}
+	if (o.set["min-spp"] || o.set["max-spp"]) && !o.set["adaptive"] {
+		return 0, errors.New("-min-spp and -max-spp need -adaptive")
+	}
+	if o.set["tile-order"] {
+		if _, err := ParseTileOrder(o.tileOrder); err != nil {
+			return 0, err
+		}
+	}
+	if o.set["tonemap"] {
+		if _, err := ParseToneMapper(o.toneMapper); err != nil {
+			return 0, err
+		}
+	}
+	if o.sampleMap != "" {
+		format, err := FormatFromName(o.sampleMap)
+		if err != nil {
+			return 0, fmt.Errorf("-sample-map: %v", err)
+		}
+		if format.IsHDR() {
+			return 0, fmt.Errorf("-sample-map: %v is not an 8-bit format", format)
+		}
+		if o.sampleMap == o.output {
+			return 0, fmt.Errorf("-sample-map: %s is also the output", o.sampleMap)
+		}
+	}
 
-	var box2 Hittable
-	box2 = NewBox(NewVec3(0, 0, 0), NewVec3(165, 165, 165), white)
-	box2 = NewRotateY(box2, -18)
-	box2 = NewTranslate(box2, NewVec3(130, 0, 65))
+	if o.format != "" {
+		return ParseFormat(o.format)
+	}
+	format, err := FormatFromName(o.output)
+	if err != nil {
+		return 0, fmt.Errorf("%v; use -format", err)
+	}
+	return format, nil
+}
 
-	world.Add(NewConstantMedium(box1, 0.01, NewSolidColor(0, 0, 0)))
-	world.Add(NewConstantMedium(box2, 0.01, NewSolidColor(1, 1, 1)))
+func (o *renderOptions) loadScene() (*Scene, error) {
+	if build, ok := builtinScenes[o.scene]; ok {
+		// the same seed builds the same world and renders the same image
+		rand.Seed(o.seed)
+		scene, err := build()
+		if err != nil {
+			return nil, err
+		}
+		scene.Seed = uint64(o.seed)
+		return scene, nil
+	}
 
-	return world
+	if _, err := os.Stat(o.scene); err != nil {
+		return nil, fmt.Errorf("%q is neither a built-in scene (%s) nor a readable file", o.scene, strings.Join(builtinSceneNames(), ", "))
+	}
+	scene, err := LoadScene(o.scene)
+	if err != nil {
+		return nil, err
+	}
+	if o.set["seed"] {
+		scene.Seed = uint64(o.seed)
+	}
+	return scene, nil
 }
 
-func finalScene() Hittable {
-	boxes1 := NewHittableList()
+func (o *renderOptions) apply(scene *Scene) error {
+	if o.set["spp"] {
+		scene.SamplesPerPixel = o.spp
+	}
+	if o.set["spp-pass"] {
+		scene.SamplesPerPass = o.sppPass
+	}
+	if o.set["depth"] {
+		scene.MaxDepth = o.depth
+	}
+	if o.set["threads"] {
+		scene.Threads = o.threads
+	}
+	if o.set["tile-size"] {
+		scene.TileSize = o.tileSize
+	}
+	if o.set["tile-order"] {
+		scene.TileOrder, _ = ParseTileOrder(o.tileOrder)
+	}
+	if o.set["tonemap"] {
+		scene.ToneMapper, _ = ParseToneMapper(o.toneMapper)
+	}
+	if o.set["exposure"] {
+		scene.Exposure = o.exposure
+	}
+	if o.set["adaptive"] {
+		maxSpp := o.maxSpp
+		if !o.set["max-spp"] {
+			maxSpp = 4 * scene.SamplesPerPixel
+		}
+		if o.minSpp > maxSpp {
+			return fmt.Errorf("-min-spp %d is larger than -max-spp %d", o.minSpp, maxSpp)
+		}
+		scene.Adaptive = NewAdaptiveSampling(o.minSpp, maxSpp, o.adaptive)
+	}
 
-	matGround := NewLambertian(NewSolidColor(0.48, 0.83, 0.53))
+	params := scene.Camera.Params
+	rebuild := false
 
-	boxesPerSide := 20
-	for i := 0; i < boxesPerSide; i++ {
-		for j := 0; j < boxesPerSide; j++ {
-			w := 100.0
-			x0 := -1000.0 + float64(i)*w
-			x1 := x0 + w
+	if o.set["width"] || o.set["height"] {
+		width, height := o.width, o.height
+		if !o.set["height"] {
+			height = int(float64(width) * float64(scene.Height) / float64(scene.Width))
+		}
+		if !o.set["width"] {
+			width = int(float64(height) * float64(scene.Width) / float64(scene.Height))
+		}
+		if width < 2 || height < 2 {
+			return fmt.Errorf("image would be %dx%d pixels", width, height)
+		}
+		scene.Resize(width, height)
+		params.AspectRatio = float64(width) / float64(height)
+		rebuild = true
+	}
+
+	cameraFlags := []struct {
+		name  string
+		apply func()
+	}{
+		{"look-from", func() { params.LookFrom = o.lookFrom.v }},
+		{"look-at", func() { params.LookAt = o.lookAt.v }},
+		{"up", func() { params.VUp = o.up.v }},
+		{"vfov", func() { params.VFOV = o.vFOV }},
+		{"aperture", func() { params.Aperture = o.aperture }},
+		{"focus-dist", func() { params.FocusDist = o.focusDist }},
+	}
+	for _, flag := range cameraFlags {
+		if o.set[flag.name] {
+			flag.apply()
+			rebuild = true
+		}
+	}
+
+	if rebuild {
+		if params.LookFrom == params.LookAt {
+			return errors.New("camera position and target are the same point")
+		}
+		if params.VUp.Cross(params.LookFrom.Sub(params.LookAt)).NearZero() {
+			return errors.New("camera up vector is parallel to the view direction")
+		}
 
-			z0 := -1000.0 + float64(j)*w
-			z1 := z0 + w
+		camera := NewCameraFromParams(params)
+		camera.Time0 = scene.Camera.Time0
+		camera.Time1 = scene.Camera.Time1
+		scene.Camera = camera
+	}
 
-			y0 := 0.0
-			y1 := Random(0, 101)
+	return nil
+}
 
-			boxes1.Add(NewBox(NewVec3(x0, y0, z0), NewVec3(x1, y1, z1), matGround))
+func (o *renderOptions) render(scene *Scene, format Format) int {
+	// refreshing stdout after every pass would concatenate images, so a
+	// refresh only writes files
+	write := func(refresh bool) error {
+		if !refresh || o.output != "-" {
+			if err := scene.WriteToFileAs(o.output, format); err != nil {
+				return err
+			}
 		}
+		if o.sampleMap != "" && (!refresh || o.sampleMap != "-") {
+			return writeSampleMap(scene, o.sampleMap)
+		}
+		return nil
 	}
 
-	world := NewHittableList()
+	// resume from an earlier, interrupted render
+	if o.checkpoint != "" {
+		if err := scene.LoadCheckpoint(o.checkpoint); err != nil && !os.IsNotExist(err) {
+			log.Print(err)
+			return exitFailure
+		}
+	}
 
-	world.Add(NewBVHNode(boxes1, 0, 1))
+	var passErr error
+	scene.OnPass = func(pass int) {
+		if passErr != nil {
+			return
+		}
+		passErr = write(true)
+		if passErr == nil && o.checkpoint != "" {
+			passErr = scene.SaveCheckpoint(o.checkpoint)
+		}
+	}
+
+	bar := pb.New(0)
+	if !o.quiet {
+		scene.OnProgress = func(p Progress) {
+			if !bar.IsStarted() {
+				bar.SetTotal(int64(p.TilesTotal))
+				bar.Start()
+			}
+			bar.SetCurrent(int64(p.TilesDone))
+		}
+	}
 
-	light := NewDiffuseLight(NewSolidColor(7, 7, 7))
-	world.Add(NewXZRect(123, 147, 423, 412, 554, light))
+	// stop on Ctrl-C, keeping what has been rendered so far
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	defer signal.Stop(interrupt)
+	go func() {
+		select {
+		case <-interrupt:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	renderErr := scene.Render(ctx)
+	bar.Finish()
+
+	if passErr != nil {
+		log.Print(passErr)
+		return exitFailure
+	}
+	if err := write(false); err != nil {
+		log.Print(err)
+		return exitFailure
+	}
+
+	if renderErr != nil {
+		if o.checkpoint != "" {
+			if err := scene.SaveCheckpoint(o.checkpoint); err != nil {
+				log.Print(err)
+				return exitFailure
+			}
+		}
+		log.Printf("render: %v; partial image written to %s", renderErr, o.output)
+		return exitInterrupted
+	}
 
-	center1 := NewVec3(400, 400, 200)
-	center2 := center1.Add(NewVec3(30, 0, 0))
-	matMovingSphere := NewLambertian(NewSolidColor(0.7, 0.3, 0.1))
-	world.Add(NewMovingSphere(center1, center2, 0, 1, 50, matMovingSphere))
+	return exitOK
+}
 
-	world.Add(NewSphere(NewVec3(260, 150, 45), 50, NewDielectric(1.5)))
-	world.Add(NewSphere(NewVec3(0, 150, 145), 50, NewMetal(NewVec3(0.8, 0.8, 0.9), 1.0)))
+// reportTextures logs the size and memory of every image texture.
+func reportTextures(scene *Scene) {
+	total := 0
+	textures := ImageTextures(scene.World)
+	for _, tex := range textures {
+		name := tex.Name
+		if name == "" {
+			name = "(unnamed)"
+		}
+		layout := "rows"
+		if tex.Tiled() {
+			layout = "tiled"
+		}
+		log.Printf("texture %s: %dx%d, %s, %s", name, tex.Width, tex.Height, layout, formatBytes(tex.MemoryUsage()))
+		total += tex.MemoryUsage()
+	}
+	if len(textures) > 1 {
+		log.Printf("textures: %d, %s", len(textures), formatBytes(total))
+	}
+}
 
-	boundary := NewSphere(NewVec3(360, 150, 145), 70, NewDielectric(1.5))
-	world.Add(boundary)
-	world.Add(NewConstantMedium(boundary, 0.2, NewSolidColor(0.2, 0.4, 0.9)))
-	boundary = NewSphere(NewVec3(0, 0, 0), 5000, NewDielectric(1.5))
-	world.Add(NewConstantMedium(boundary, 0.0001, NewSolidColor(1, 1, 1)))
+func formatBytes(n int) string {
+	switch {
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1f MiB", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.1f KiB", float64(n)/(1<<10))
+	}
+	return fmt.Sprintf("%d B", n)
+}
 
-	texEarth := NewImageTexture("earthmap.jpg")
-	world.Add(NewSphere(NewVec3(400, 200, 400), 100, NewLambertian(texEarth)))
-	// noise
+func writeSampleMap(scene *Scene, name string) error {
+	format, err := FormatFromName(name)
+	if err != nil {
+		return err
+	}
 
-	boxes2 := NewHittableList()
-	white := NewLambertian(NewSolidColor(0.73, 0.73, 0.73))
-	ns := 1000
-	for j := 0; j < ns; j++ {
-		boxes2.Add(NewSphere(RandomVec3In(0, 165), 10, white))
+	maxSamples := scene.SamplesPerPixel
+	if scene.Adaptive != nil {
+		maxSamples = scene.Adaptive.MaxSamples
 	}
 
-	world.Add(NewTranslate(NewRotateY(NewBVHNode(boxes2, 0, 1), 15), NewVec3(-100, 270, 395)))
+	img := scene.Buffer.SampleCountImage(maxSamples)
+	if name == "-" {
+		return img.Encode(os.Stdout, format)
+	}
 
-	return world
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	if err := img.Encode(f, format); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
 }
@@ -33,6 +33,29 @@ func (a *AABB) Hit(r *Ray, tMin, tMax float64) bool {
 	return true
 }
 
+// hitInv is Hit with the reciprocal of the ray direction worked out by the
+// caller, for traversals that test many boxes against one ray.
+func (a *AABB) hitInv(r *Ray, invDir Vec3, tMin, tMax float64) bool {
+	for i := 0; i < 3; i++ {
+		t0 := (a.Min[i] - r.Origin[i]) * invDir[i]
+		t1 := (a.Max[i] - r.Origin[i]) * invDir[i]
+		if invDir[i] < 0 {
+			t0, t1 = t1, t0
+		}
+		if t0 > tMin {
+			tMin = t0
+		}
+		if t1 < tMax {
+			tMax = t1
+		}
+		if tMax <= tMin {
+			return false
+		}
+	}
+
+	return true
+}
+
 func SurroundingBox(box0, box1 *AABB) *AABB {
 	small := NewVec3(
 		math.Min(box0.Min[0], box1.Min[0]),
@@ -46,3 +69,29 @@ func SurroundingBox(box0, box1 *AABB) *AABB {
 	)
 	return NewAABB(small, big)
 }
+
+func (a *AABB) SurfaceArea() float64 {
+	d := a.Max.Sub(a.Min)
+	if d[0] < 0 || d[1] < 0 || d[2] < 0 {
+		return 0
+	}
+	return 2 * (d[0]*d[1] + d[1]*d[2] + d[2]*d[0])
+}
+
+// emptyBox contains nothing, so that unionBox with it gives the other box.
+func emptyBox() AABB {
+	posInf := math.Inf(1)
+	negInf := math.Inf(-1)
+	return AABB{
+		Min: NewVec3(posInf, posInf, posInf),
+		Max: NewVec3(negInf, negInf, negInf),
+	}
+}
+
+// unionBox is SurroundingBox without the allocation, for builders.
+func unionBox(a, b AABB) AABB {
+	return AABB{
+		Min: NewVec3(math.Min(a.Min[0], b.Min[0]), math.Min(a.Min[1], b.Min[1]), math.Min(a.Min[2], b.Min[2])),
+		Max: NewVec3(math.Max(a.Max[0], b.Max[0]), math.Max(a.Max[1], b.Max[1]), math.Max(a.Max[2], b.Max[2])),
+	}
+}
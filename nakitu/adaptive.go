@@ -0,0 +1,57 @@
+package nakitu
+
+// AdaptiveSampling stops sampling a pixel once the relative standard error of
+// its luminance drops below Threshold. SamplesPerPixel then becomes the
+// average budget: rendering ends when the image has used
+// Width*Height*SamplesPerPixel samples, so the samples saved on converged
+// pixels go to the noisy ones.
+type AdaptiveSampling struct {
+	MinSamples int
+	MaxSamples int
+	Threshold  float64
+}
+
+func NewAdaptiveSampling(minSamples, maxSamples int, threshold float64) *AdaptiveSampling {
+	return &AdaptiveSampling{
+		MinSamples: minSamples,
+		MaxSamples: maxSamples,
+		Threshold:  threshold,
+	}
+}
+
+func (a *AdaptiveSampling) converged(buffer *FrameBuffer, index int) bool {
+	if buffer.Samples[index] < a.MinSamples {
+		return false
+	}
+	return buffer.RelativeError(index) < a.Threshold
+}
+
+func (s *Scene) maxSamples() int {
+	if s.Adaptive != nil {
+		return s.Adaptive.MaxSamples
+	}
+	return s.SamplesPerPixel
+}
+
+// pixelBudget is the number of samples a pixel may still take.
+func (s *Scene) pixelBudget(index int) int {
+	done := s.Buffer.Samples[index]
+	if s.Adaptive != nil && s.Adaptive.converged(s.Buffer, index) {
+		return 0
+	}
+	return s.maxSamples() - done
+}
+
+// needsSamples reports whether another pass would add any samples.
+func (s *Scene) needsSamples() bool {
+	if s.Adaptive != nil && s.Buffer.TotalSamples() >= int64(s.Width*s.Height*s.SamplesPerPixel) {
+		return false
+	}
+
+	for i := range s.Buffer.Samples {
+		if s.pixelBudget(i) > 0 {
+			return true
+		}
+	}
+	return false
+}
@@ -2,82 +2,348 @@ package nakitu
 
 import (
 	"log"
-	"sort"
+	"math"
+	"sync"
 )
 
+const (
+	// bvhMaxLeafSize is the most objects kept in one leaf of a BVHNode.
+	bvhMaxLeafSize = 4
+
+	// bvhBins is the number of buckets the SAH builder sorts centroids into.
+	bvhBins = 16
+
+	// sahTraversalCost is the cost of visiting a node relative to testing
+	// one primitive.
+	sahTraversalCost = 0.125
+
+	// bvhParallelThreshold is the span above which the children of a node
+	// are built concurrently.
+	bvhParallelThreshold = 4096
+)
+
+// BVHStats describes a built tree. SAHCost is the expected cost of a random
+// ray through the root, in units of primitive tests.
+type BVHStats struct {
+	Nodes      int
+	Leaves     int
+	Depth      int
+	Primitives int
+	SAHCost    float64
+}
+
+// BVHNode is a bounding volume hierarchy over a list of objects, stored as a
+// flat array of nodes in depth-first order. Leaves hold up to bvhMaxLeafSize
+// objects.
 type BVHNode struct {
-	Left  Hittable
-	Right Hittable
-	Box   *AABB
+	Box *AABB
+
+	nodes   []bvhNode
+	objects []Hittable
+	stats   BVHStats
 }
 
-func NewBVHNode(list *HittableList, time0, time1 float64) *BVHNode {
-	return newBVHNode(list.Objects, 0, len(list.Objects), time0, time1)
+// bvhNode is a node of a flattened BVH. The left child of an interior node
+// directly follows it and right is the index of the right child; a leaf has
+// a right of -1 and covers the primitives [first, first+count) of the tree's
+// order, which is empty for a tree over nothing.
+type bvhNode struct {
+	box   AABB
+	right int32
+	first int32
+	count int32
+	axis  int32
 }
 
-func newBVHNode(srcObjects []Hittable, start, end int, time0, time1 float64) *BVHNode {
-	node := &BVHNode{}
+func (n *bvhNode) leaf() bool {
+	return n.right < 0
+}
+
+func NewBVHNode(list *HittableList, time0, time1 float64) *BVHNode {
+	boxes := make([]AABB, len(list.Objects))
+	for i, obj := range list.Objects {
+		if !obj.BoundingBox(time0, time1, &boxes[i]) {
+			log.Fatalln("No bounding box in BVHNode constructor.")
+		}
+	}
 
-	objects := make([]Hittable, len(srcObjects))
-	copy(objects, srcObjects)
+	build := buildBVH(boxes, bvhMaxLeafSize)
+	b := &BVHNode{
+		Box:     NewAABB(build.root.box.Min, build.root.box.Max),
+		nodes:   flattenBVH(build.root, build.stats.Nodes),
+		objects: make([]Hittable, len(list.Objects)),
+		stats:   build.stats,
+	}
+	for i, index := range build.order {
+		b.objects[i] = list.Objects[index]
+	}
+
+	return b
+}
 
-	axis := RandomInt(0, 3)
-	objectSpan := end - start
+// flattenBVH lays out a built tree in depth-first order.
+func flattenBVH(root *bvhBuildNode, size int) []bvhNode {
+	nodes := make([]bvhNode, 0, size)
 
-	switch objectSpan {
-	case 1:
-		node.Left = objects[start]
-		node.Right = objects[start]
-	case 2:
-		if boxCompare(objects[start], objects[start+1], axis) {
-			node.Left = objects[start]
-			node.Right = objects[start+1]
-		} else {
-			node.Left = objects[start+1]
-			node.Right = objects[start]
+	var flatten func(n *bvhBuildNode) int32
+	flatten = func(n *bvhBuildNode) int32 {
+		index := int32(len(nodes))
+		nodes = append(nodes, bvhNode{box: n.box})
+		if n.leaf() {
+			nodes[index].right = -1
+			nodes[index].first = int32(n.first)
+			nodes[index].count = int32(n.count)
+			return index
 		}
-	default:
-		sort.Slice(objects, func(i, j int) bool {
-			return boxCompare(objects[i], objects[j], axis)
-		})
 
-		mid := start + objectSpan/2
-		node.Left = newBVHNode(objects, start, mid, time0, time1)
-		node.Right = newBVHNode(objects, mid, end, time0, time1)
+		flatten(n.children[0])
+		right := flatten(n.children[1])
+		nodes[index].right = right
+		nodes[index].axis = int32(n.axis)
+		return index
 	}
+	flatten(root)
 
-	var boxLeft, boxRight AABB
-	if !node.Left.BoundingBox(time0, time1, &boxLeft) || !node.Right.BoundingBox(time0, time1, &boxRight) {
-		log.Fatalln("No bounding box in BVHNode constructor.")
+	return nodes
+}
+
+func (b *BVHNode) Stats() BVHStats {
+	return b.stats
+}
+
+// Hit walks the tree with an explicit stack, nearer child first, and narrows
+// tMax to every hit found so that farther boxes and objects are skipped.
+func (b *BVHNode) Hit(r *Ray, tMin, tMax float64, rec *HitRecord, rnd *Rand) bool {
+	// a path from the root can push at most one node per level
+	var buf [64]int32
+	stack := buf[:]
+	if b.stats.Depth > len(buf) {
+		stack = make([]int32, b.stats.Depth)
+	}
+	top := 0
+	node := int32(0)
+
+	invDir := NewVec3(1/r.Dir[0], 1/r.Dir[1], 1/r.Dir[2])
+	hitAnything := false
+	for {
+		n := &b.nodes[node]
+		if n.box.hitInv(r, invDir, tMin, tMax) {
+			if n.leaf() {
+				for _, obj := range b.objects[n.first : n.first+n.count] {
+					if obj.Hit(r, tMin, tMax, rec, rnd) {
+						hitAnything = true
+						tMax = rec.T
+					}
+				}
+			} else if r.Dir[n.axis] < 0 {
+				stack[top] = node + 1
+				top++
+				node = n.right
+				continue
+			} else {
+				stack[top] = n.right
+				top++
+				node++
+				continue
+			}
+		}
+
+		if top == 0 {
+			break
+		}
+		top--
+		node = stack[top]
 	}
 
-	node.Box = SurroundingBox(&boxLeft, &boxRight)
+	return hitAnything
+}
+
+func (b *BVHNode) BoundingBox(time0, time1 float64, outputBox *AABB) bool {
+	*outputBox = *b.Box
+	return true
+}
+
+// bvhBuildNode is a node of the tree made by buildBVH. Leaves cover
+// order[first:first+count].
+type bvhBuildNode struct {
+	box      AABB
+	children [2]*bvhBuildNode
+	axis     int
+	first    int
+	count    int
+}
+
+func (n *bvhBuildNode) leaf() bool {
+	return n.children[0] == nil
+}
+
+type bvhBuild struct {
+	root  *bvhBuildNode
+	order []int
+	stats BVHStats
+}
 
-	return node
+type bvhBuilder struct {
+	boxes     []AABB
+	centroids []Point3
+	order     []int
+	maxLeaf   int
 }
 
-func boxCompare(a, b Hittable, axis int) bool {
-	var boxA, boxB AABB
+// buildBVH builds a tree over primitives with the given boxes using the
+// surface area heuristic, evaluated over bvhBins buckets on every axis. Large
+// spans are built in parallel. The leaves index into the returned order.
+func buildBVH(boxes []AABB, maxLeaf int) *bvhBuild {
+	b := &bvhBuilder{
+		boxes:     boxes,
+		centroids: make([]Point3, len(boxes)),
+		order:     make([]int, len(boxes)),
+		maxLeaf:   maxLeaf,
+	}
+	for i, box := range boxes {
+		b.centroids[i] = box.Min.Add(box.Max).Mulf(0.5)
+		b.order[i] = i
+	}
 
-	if !a.BoundingBox(0, 0, &boxA) || !b.BoundingBox(0, 0, &boxB) {
-		log.Fatalln("No bounding box in BVHNode constructor.")
+	root := b.build(0, len(boxes))
+
+	build := &bvhBuild{root: root, order: b.order}
+	build.stats.Primitives = len(boxes)
+	rootArea := root.box.SurfaceArea()
+	var walk func(n *bvhBuildNode, depth int)
+	walk = func(n *bvhBuildNode, depth int) {
+		build.stats.Nodes++
+		if depth > build.stats.Depth {
+			build.stats.Depth = depth
+		}
+
+		weight := 1.0
+		if rootArea > 0 {
+			weight = n.box.SurfaceArea() / rootArea
+		}
+		if n.leaf() {
+			build.stats.Leaves++
+			build.stats.SAHCost += weight * float64(n.count)
+			return
+		}
+		build.stats.SAHCost += weight * sahTraversalCost
+		walk(n.children[0], depth+1)
+		walk(n.children[1], depth+1)
 	}
+	walk(root, 1)
 
-	return boxA.Min[axis] < boxB.Min[axis]
+	return build
 }
 
-func (b *BVHNode) Hit(r *Ray, tMin, tMax float64, rec *HitRecord) bool {
-	if !b.Box.Hit(r, tMin, tMax) {
-		return false
+func (b *bvhBuilder) build(first, count int) *bvhBuildNode {
+	n := &bvhBuildNode{first: first, count: count, box: emptyBox()}
+	centroidBox := emptyBox()
+	for _, i := range b.order[first : first+count] {
+		n.box = unionBox(n.box, b.boxes[i])
+		centroidBox = unionBox(centroidBox, AABB{Min: b.centroids[i], Max: b.centroids[i]})
+	}
+	if count == 1 {
+		return n
 	}
 
-	hitLeft := b.Left.Hit(r, tMin, tMax, rec)
-	hitRight := b.Right.Hit(r, tMin, tMax, rec)
+	axis, split, cost := b.bestSplit(first, count, &n.box, &centroidBox)
+	if axis < 0 || (count <= b.maxLeaf && cost >= float64(count)) {
+		return n
+	}
 
-	return hitLeft || hitRight
+	// partition by bucket, so both sides are non-empty
+	items := b.order[first : first+count]
+	lo, extent := centroidBox.Min[axis], centroidBox.Max[axis]-centroidBox.Min[axis]
+	mid := 0
+	for i := range items {
+		if bvhBin(b.centroids[items[i]][axis], lo, extent) < split {
+			items[i], items[mid] = items[mid], items[i]
+			mid++
+		}
+	}
+
+	n.axis = axis
+	if count > bvhParallelThreshold {
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			n.children[0] = b.build(first, mid)
+			wg.Done()
+		}()
+		n.children[1] = b.build(first+mid, count-mid)
+		wg.Wait()
+	} else {
+		n.children[0] = b.build(first, mid)
+		n.children[1] = b.build(first+mid, count-mid)
+	}
+
+	return n
 }
 
-func (b *BVHNode) BoundingBox(time0, time1 float64, outputBox *AABB) bool {
-	*outputBox = *b.Box
-	return true
+// bestSplit finds the cheapest bucket boundary over all axes. It returns an
+// axis of -1 if the centroids cannot be separated.
+func (b *bvhBuilder) bestSplit(first, count int, box, centroidBox *AABB) (axis, split int, cost float64) {
+	axis, cost = -1, math.Inf(1)
+	area := box.SurfaceArea()
+
+	for a := 0; a < 3; a++ {
+		lo, extent := centroidBox.Min[a], centroidBox.Max[a]-centroidBox.Min[a]
+		if extent <= 0 {
+			continue
+		}
+
+		var counts [bvhBins]int
+		var boxes [bvhBins]AABB
+		for i := range boxes {
+			boxes[i] = emptyBox()
+		}
+		for _, i := range b.order[first : first+count] {
+			k := bvhBin(b.centroids[i][a], lo, extent)
+			counts[k]++
+			boxes[k] = unionBox(boxes[k], b.boxes[i])
+		}
+
+		// sweep from the right to get the area and count right of every
+		// boundary, then from the left to evaluate them
+		var rightArea [bvhBins]float64
+		var rightCount [bvhBins]int
+		right, n := emptyBox(), 0
+		for k := bvhBins - 1; k > 0; k-- {
+			right = unionBox(right, boxes[k])
+			n += counts[k]
+			rightArea[k], rightCount[k] = right.SurfaceArea(), n
+		}
+
+		left, n := emptyBox(), 0
+		for k := 1; k < bvhBins; k++ {
+			left = unionBox(left, boxes[k-1])
+			n += counts[k-1]
+			if n == 0 || rightCount[k] == 0 {
+				continue
+			}
+
+			c := sahTraversalCost
+			if area > 0 {
+				c += (left.SurfaceArea()*float64(n) + rightArea[k]*float64(rightCount[k])) / area
+			} else {
+				c += float64(count)
+			}
+			if c < cost {
+				axis, split, cost = a, k, c
+			}
+		}
+	}
+
+	return axis, split, cost
+}
+
+func bvhBin(x, lo, extent float64) int {
+	k := int(bvhBins * (x - lo) / extent)
+	if k >= bvhBins {
+		k = bvhBins - 1
+	}
+	if k < 0 {
+		k = 0
+	}
+	return k
 }
@@ -13,6 +13,20 @@ type Camera struct {
 	LensRadius      float64
 	Time0           float64
 	Time1           float64
+
+	Params CameraParams
+}
+
+// CameraParams are the arguments NewCamera was called with, kept so that a
+// camera can be rebuilt with some of them changed.
+type CameraParams struct {
+	LookFrom    Point3
+	LookAt      Point3
+	VUp         Vec3
+	VFOV        float64
+	AspectRatio float64
+	Aperture    float64
+	FocusDist   float64
 }
 
 func NewCamera(
@@ -53,11 +67,24 @@ func NewCamera(
 		LensRadius:      lendRadius,
 		Time0:           0,
 		Time1:           0,
+		Params: CameraParams{
+			LookFrom:    lookFrom,
+			LookAt:      lookAt,
+			VUp:         vUp,
+			VFOV:        vfov,
+			AspectRatio: aspectRatio,
+			Aperture:    aperture,
+			FocusDist:   focusDist,
+		},
 	}
 }
 
-func (c *Camera) GetRay(s, t float64) *Ray {
-	rd := RandomInUnitDisk().Mulf(c.LensRadius)
+func NewCameraFromParams(p CameraParams) *Camera {
+	return NewCamera(p.LookFrom, p.LookAt, p.VUp, p.VFOV, p.AspectRatio, p.Aperture, p.FocusDist)
+}
+
+func (c *Camera) GetRay(s, t float64, rnd *Rand) *Ray {
+	rd := RandomInUnitDisk(rnd).Mulf(c.LensRadius)
 	offset := c.U.Mulf(rd.X()).Add(c.V.Mulf(rd.Y()))
 
 	return NewRay(
@@ -67,7 +94,7 @@ func (c *Camera) GetRay(s, t float64) *Ray {
 			Add(c.Vertical.Mulf(t)).
 			Sub(c.Origin).
 			Sub(offset),
-		Random(c.Time0, c.Time1),
+		rnd.Random(c.Time0, c.Time1),
 	)
 
 }
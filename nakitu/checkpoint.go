@@ -0,0 +1,125 @@
+package nakitu
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+var checkpointMagic = [4]byte{'N', 'K', 'C', 'P'}
+
+const checkpointVersion = 2
+
+type checkpointHeader struct {
+	Magic   [4]byte
+	Version uint32
+	Width   uint32
+	Height  uint32
+	Seed    uint64
+}
+
+type checkpointPixel struct {
+	Sum        [3]float64
+	SumSquares float64
+	Samples    uint32
+}
+
+// SaveCheckpoint stores the accumulated samples so that a later Render can
+// resume from them. The file is replaced atomically.
+func (s *Scene) SaveCheckpoint(name string) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(name), filepath.Base(name)+".*")
+	if err != nil {
+		return err
+	}
+
+	if err := s.writeCheckpoint(tmp); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	return os.Rename(tmp.Name(), name)
+}
+
+func (s *Scene) writeCheckpoint(w io.Writer) error {
+	buf := bufio.NewWriter(w)
+
+	header := checkpointHeader{
+		Magic:   checkpointMagic,
+		Version: checkpointVersion,
+		Width:   uint32(s.Width),
+		Height:  uint32(s.Height),
+		Seed:    s.Seed,
+	}
+	if err := binary.Write(buf, binary.LittleEndian, &header); err != nil {
+		return err
+	}
+
+	pixels := make([]checkpointPixel, len(s.Buffer.Sum))
+	for i := range pixels {
+		pixels[i].Sum = s.Buffer.Sum[i]
+		pixels[i].SumSquares = s.Buffer.SumSquares[i]
+		pixels[i].Samples = uint32(s.Buffer.Samples[i])
+	}
+	if err := binary.Write(buf, binary.LittleEndian, pixels); err != nil {
+		return err
+	}
+
+	return buf.Flush()
+}
+
+// LoadCheckpoint replaces Buffer with the samples stored by SaveCheckpoint.
+// The checkpoint must come from a scene with the same size and seed.
+func (s *Scene) LoadCheckpoint(name string) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	buf := bufio.NewReader(f)
+
+	var header checkpointHeader
+	if err := binary.Read(buf, binary.LittleEndian, &header); err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	if header.Magic != checkpointMagic {
+		return fmt.Errorf("%s: not a checkpoint file", name)
+	}
+	if header.Version != checkpointVersion {
+		return fmt.Errorf("%s: unsupported checkpoint version %d", name, header.Version)
+	}
+	if int(header.Width) != s.Width || int(header.Height) != s.Height {
+		return fmt.Errorf("%s: checkpoint is %dx%d, scene is %dx%d", name, header.Width, header.Height, s.Width, s.Height)
+	}
+	if header.Seed != s.Seed {
+		return fmt.Errorf("%s: checkpoint seed %d does not match scene seed %d", name, header.Seed, s.Seed)
+	}
+
+	pixels := make([]checkpointPixel, s.Width*s.Height)
+	if err := binary.Read(buf, binary.LittleEndian, pixels); err != nil {
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		return fmt.Errorf("%s: %w", name, err)
+	}
+
+	buffer := NewFrameBuffer(s.Width, s.Height)
+	for i, pixel := range pixels {
+		buffer.Sum[i] = pixel.Sum
+		buffer.SumSquares[i] = pixel.SumSquares
+		buffer.Samples[i] = int(pixel.Samples)
+	}
+
+	s.Buffer = buffer
+	return nil
+}
@@ -0,0 +1,214 @@
+package nakitu
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+)
+
+// FrameBuffer accumulates linear radiance per pixel. Pixels are stored top to
+// bottom, the same way as Image.
+type FrameBuffer struct {
+	Width      int
+	Height     int
+	Sum        []Color
+	SumSquares []float64
+	Samples    []int
+}
+
+func NewFrameBuffer(width, height int) *FrameBuffer {
+	return &FrameBuffer{
+		Width:      width,
+		Height:     height,
+		Sum:        make([]Color, width*height),
+		SumSquares: make([]float64, width*height),
+		Samples:    make([]int, width*height),
+	}
+}
+
+// Add accumulates samples for a pixel. sumSquares is the sum of the squared
+// luminance of the samples and drives the variance estimate.
+func (f *FrameBuffer) Add(x, y int, sum Color, sumSquares float64, samples int) {
+	index := x + y*f.Width
+	f.Sum[index] = f.Sum[index].Add(sum)
+	f.SumSquares[index] += sumSquares
+	f.Samples[index] += samples
+}
+
+func (f *FrameBuffer) Pixel(x, y int) Color {
+	index := x + y*f.Width
+	if f.Samples[index] == 0 {
+		return Zero()
+	}
+	return f.Sum[index].Divf(float64(f.Samples[index]))
+}
+
+// Variance is the sample variance of the luminance of a pixel.
+func (f *FrameBuffer) Variance(index int) float64 {
+	n := float64(f.Samples[index])
+	if n < 2 {
+		return math.Inf(1)
+	}
+
+	mean := Luminance(f.Sum[index]) / n
+	return math.Max(f.SumSquares[index]/n-mean*mean, 0) * n / (n - 1)
+}
+
+// RelativeError is the standard error of the mean luminance of a pixel
+// relative to the mean itself.
+func (f *FrameBuffer) RelativeError(index int) float64 {
+	variance := f.Variance(index)
+	if variance == 0 {
+		return 0
+	}
+
+	n := float64(f.Samples[index])
+	mean := Luminance(f.Sum[index]) / n
+	return math.Sqrt(variance/n) / math.Max(mean, 1e-3)
+}
+
+func (f *FrameBuffer) TotalSamples() int64 {
+	total := int64(0)
+	for _, n := range f.Samples {
+		total += int64(n)
+	}
+	return total
+}
+
+// SampleCountImage shows the samples taken per pixel, scaled so that
+// maxSamples is white.
+func (f *FrameBuffer) SampleCountImage(maxSamples int) *Image {
+	img := NewImage(f.Width, f.Height)
+	for i, n := range f.Samples {
+		v := int(255 * Clamp(float64(n)/float64(maxSamples), 0, 1))
+		img.Pixels[i] = NewRGB(v, v, v)
+	}
+	return img
+}
+
+func (f *FrameBuffer) Clear() {
+	for i := range f.Sum {
+		f.Sum[i] = Zero()
+		f.SumSquares[i] = 0
+		f.Samples[i] = 0
+	}
+}
+
+func (f *FrameBuffer) Encode(w io.Writer, format Format) error {
+	switch format {
+	case FormatHDR:
+		return f.WriteHDR(w)
+	case FormatPFM:
+		return f.WritePFM(w)
+	}
+	return fmt.Errorf("unsupported HDR format %v", format)
+}
+
+// WriteHDR writes a Radiance RGBE image with run-length encoded scanlines.
+func (f *FrameBuffer) WriteHDR(w io.Writer) error {
+	buf := bufio.NewWriter(w)
+	fmt.Fprintf(buf, "#?RADIANCE\nFORMAT=32-bit_rle_rgbe\n\n-Y %d +X %d\n", f.Height, f.Width)
+
+	scanline := make([]byte, 4*f.Width)
+	channel := make([]byte, f.Width)
+	for y := 0; y < f.Height; y++ {
+		for x := 0; x < f.Width; x++ {
+			rgbe := toRGBE(f.Pixel(x, y))
+			copy(scanline[4*x:], rgbe[:])
+		}
+
+		if f.Width < 8 || f.Width > 0x7fff {
+			buf.Write(scanline)
+			continue
+		}
+
+		buf.Write([]byte{2, 2, byte(f.Width >> 8), byte(f.Width & 0xff)})
+		for c := 0; c < 4; c++ {
+			for x := 0; x < f.Width; x++ {
+				channel[x] = scanline[4*x+c]
+			}
+			writeRLE(buf, channel)
+		}
+	}
+
+	return buf.Flush()
+}
+
+// WritePFM writes a little-endian Portable Float Map, stored bottom to top.
+func (f *FrameBuffer) WritePFM(w io.Writer) error {
+	buf := bufio.NewWriter(w)
+	fmt.Fprintf(buf, "PF\n%d %d\n-1.0\n", f.Width, f.Height)
+
+	row := make([]float32, 3*f.Width)
+	for y := f.Height - 1; y >= 0; y-- {
+		for x := 0; x < f.Width; x++ {
+			c := f.Pixel(x, y)
+			row[3*x] = float32(c[0])
+			row[3*x+1] = float32(c[1])
+			row[3*x+2] = float32(c[2])
+		}
+		if err := binary.Write(buf, binary.LittleEndian, row); err != nil {
+			return err
+		}
+	}
+
+	return buf.Flush()
+}
+
+func toRGBE(c Color) [4]byte {
+	v := math.Max(c[0], math.Max(c[1], c[2]))
+	if v < 1e-32 {
+		return [4]byte{}
+	}
+
+	frac, exp := math.Frexp(v)
+	scale := frac * 256 / v
+	return [4]byte{
+		byte(math.Max(c[0], 0) * scale),
+		byte(math.Max(c[1], 0) * scale),
+		byte(math.Max(c[2], 0) * scale),
+		byte(exp + 128),
+	}
+}
+
+func writeRLE(w *bufio.Writer, data []byte) {
+	const minRun = 4
+
+	cur := 0
+	for cur < len(data) {
+		begRun := cur
+		runCount := 0
+		oldRunCount := 0
+
+		for runCount < minRun && begRun < len(data) {
+			begRun += runCount
+			oldRunCount = runCount
+			runCount = 1
+			for begRun+runCount < len(data) && runCount < 127 && data[begRun] == data[begRun+runCount] {
+				runCount++
+			}
+		}
+
+		if oldRunCount > 1 && oldRunCount == begRun-cur {
+			w.Write([]byte{byte(128 + oldRunCount), data[cur]})
+			cur = begRun
+		}
+
+		for cur < begRun {
+			nonRun := begRun - cur
+			if nonRun > 128 {
+				nonRun = 128
+			}
+			w.WriteByte(byte(nonRun))
+			w.Write(data[cur : cur+nonRun])
+			cur += nonRun
+		}
+
+		if runCount >= minRun {
+			w.Write([]byte{byte(128 + runCount), data[begRun]})
+			cur += runCount
+		}
+	}
+}
@@ -0,0 +1,897 @@
+package nakitu
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"image"
+	"io/ioutil"
+	"math"
+	"net/url"
+	"path/filepath"
+	"strings"
+)
+
+// GLTFScene is what LoadGLTF imports from a glTF file.
+type GLTFScene struct {
+	World Hittable
+	// Cameras are the perspective cameras of the scene in node order. A zero
+	// AspectRatio means the file leaves it to the renderer.
+	Cameras []CameraParams
+}
+
+// LoadGLTF imports the default scene of a glTF 2.0 file, either .gltf with
+// embedded or external buffers or binary .glb. Node transforms are baked into
+// the meshes.
+//
+// Metallic-roughness materials map onto nakitu materials: emissive ones become
+// a DiffuseLight, KHR_materials_transmission a Dielectric with the
+// KHR_materials_ior index, metallic ones a Metal whose fuzz is the roughness
+// and the rest a Lambertian with the base colour and its texture. Metals only
+// use the base colour factor. Orthographic cameras are skipped.
+func LoadGLTF(name string) (*GLTFScene, error) {
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+
+	g := &gltfLoader{
+		file:     name,
+		dir:      filepath.Dir(name),
+		buffers:  map[int][]byte{},
+		textures: map[int]Texture{},
+	}
+	scene, err := g.load(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	return scene, nil
+}
+
+type gltfDocument struct {
+	Asset struct {
+		Version string `json:"version"`
+	} `json:"asset"`
+	ExtensionsRequired []string `json:"extensionsRequired"`
+
+	Scene  *int `json:"scene"`
+	Scenes []struct {
+		Nodes []int `json:"nodes"`
+	} `json:"scenes"`
+	Nodes       []gltfNode       `json:"nodes"`
+	Meshes      []gltfMesh       `json:"meshes"`
+	Accessors   []gltfAccessor   `json:"accessors"`
+	BufferViews []gltfBufferView `json:"bufferViews"`
+	Buffers     []gltfBuffer     `json:"buffers"`
+	Materials   []gltfMaterial   `json:"materials"`
+	Textures    []struct {
+		Source  *int `json:"source"`
+		Sampler *int `json:"sampler"`
+	} `json:"textures"`
+	Samplers []gltfSampler `json:"samplers"`
+	Images   []gltfImage   `json:"images"`
+	Cameras  []gltfCamera  `json:"cameras"`
+}
+
+type gltfNode struct {
+	Children    []int     `json:"children"`
+	Mesh        *int      `json:"mesh"`
+	Camera      *int      `json:"camera"`
+	Matrix      []float64 `json:"matrix"`
+	Translation []float64 `json:"translation"`
+	Rotation    []float64 `json:"rotation"`
+	Scale       []float64 `json:"scale"`
+}
+
+type gltfMesh struct {
+	Primitives []struct {
+		Attributes map[string]int `json:"attributes"`
+		Indices    *int           `json:"indices"`
+		Material   *int           `json:"material"`
+		Mode       *int           `json:"mode"`
+	} `json:"primitives"`
+}
+
+type gltfAccessor struct {
+	BufferView    *int            `json:"bufferView"`
+	ByteOffset    int             `json:"byteOffset"`
+	ComponentType int             `json:"componentType"`
+	Normalized    bool            `json:"normalized"`
+	Count         int             `json:"count"`
+	Type          string          `json:"type"`
+	Sparse        json.RawMessage `json:"sparse"`
+}
+
+type gltfBufferView struct {
+	Buffer     int `json:"buffer"`
+	ByteOffset int `json:"byteOffset"`
+	ByteLength int `json:"byteLength"`
+	ByteStride int `json:"byteStride"`
+}
+
+type gltfBuffer struct {
+	URI        string `json:"uri"`
+	ByteLength int    `json:"byteLength"`
+}
+
+type gltfSampler struct {
+	MagFilter int `json:"magFilter"`
+	WrapS     int `json:"wrapS"`
+	WrapT     int `json:"wrapT"`
+}
+
+type gltfTextureInfo struct {
+	Index int `json:"index"`
+}
+
+type gltfMaterial struct {
+	PBR struct {
+		BaseColorFactor  []float64        `json:"baseColorFactor"`
+		BaseColorTexture *gltfTextureInfo `json:"baseColorTexture"`
+		MetallicFactor   *float64         `json:"metallicFactor"`
+		RoughnessFactor  *float64         `json:"roughnessFactor"`
+	} `json:"pbrMetallicRoughness"`
+	EmissiveFactor  []float64        `json:"emissiveFactor"`
+	EmissiveTexture *gltfTextureInfo `json:"emissiveTexture"`
+	Extensions      struct {
+		Transmission *struct {
+			TransmissionFactor float64 `json:"transmissionFactor"`
+		} `json:"KHR_materials_transmission"`
+		IOR *struct {
+			IOR *float64 `json:"ior"`
+		} `json:"KHR_materials_ior"`
+		EmissiveStrength *struct {
+			EmissiveStrength *float64 `json:"emissiveStrength"`
+		} `json:"KHR_materials_emissive_strength"`
+	} `json:"extensions"`
+}
+
+type gltfImage struct {
+	URI        string `json:"uri"`
+	BufferView *int   `json:"bufferView"`
+}
+
+type gltfCamera struct {
+	Type        string `json:"type"`
+	Perspective *struct {
+		AspectRatio float64 `json:"aspectRatio"`
+		YFOV        float64 `json:"yfov"`
+	} `json:"perspective"`
+}
+
+// gltfSupportedExtensions may appear in extensionsRequired.
+var gltfSupportedExtensions = map[string]bool{
+	"KHR_materials_transmission":      true,
+	"KHR_materials_ior":               true,
+	"KHR_materials_emissive_strength": true,
+}
+
+const (
+	glbMagic     = 0x46546c67 // "glTF"
+	glbChunkJSON = 0x4e4f534a // "JSON"
+	glbChunkBIN  = 0x004e4942 // "BIN\x00"
+
+	// sampler filters and wrap modes
+	gltfNearest        = 9728
+	gltfClampToEdge    = 33071
+	gltfMirroredRepeat = 33648
+
+	// gltfMaxZeroElements limits accessors without a buffer view, whose
+	// count nothing in the file backs up
+	gltfMaxZeroElements = 1 << 24
+)
+
+type gltfLoader struct {
+	file string
+	dir  string
+	doc  gltfDocument
+
+	glbBIN   []byte
+	buffers  map[int][]byte
+	textures map[int]Texture
+
+	materials []Material
+	meshes    []Hittable
+	cameras   []CameraParams
+}
+
+func (g *gltfLoader) load(data []byte) (*GLTFScene, error) {
+	if len(data) >= 4 && binary.LittleEndian.Uint32(data) == glbMagic {
+		var err error
+		data, err = g.readGLB(data)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err := json.Unmarshal(data, &g.doc); err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(g.doc.Asset.Version, "2.") {
+		return nil, fmt.Errorf("unsupported glTF version %q", g.doc.Asset.Version)
+	}
+	for _, ext := range g.doc.ExtensionsRequired {
+		if !gltfSupportedExtensions[ext] {
+			return nil, fmt.Errorf("required extension %s is not supported", ext)
+		}
+	}
+
+	// the last material is the default for primitives without one
+	for i, m := range g.doc.Materials {
+		mat, err := g.material(m)
+		if err != nil {
+			return nil, fmt.Errorf("materials[%d]: %v", i, err)
+		}
+		g.materials = append(g.materials, mat)
+	}
+	g.materials = append(g.materials, NewLambertian(NewSolidColor(0.8, 0.8, 0.8)))
+	if len(g.materials) > math.MaxUint16 {
+		return nil, fmt.Errorf("too many materials")
+	}
+
+	var roots []int
+	switch {
+	case g.doc.Scene != nil:
+		if *g.doc.Scene < 0 || *g.doc.Scene >= len(g.doc.Scenes) {
+			return nil, fmt.Errorf("scene %d out of range", *g.doc.Scene)
+		}
+		roots = g.doc.Scenes[*g.doc.Scene].Nodes
+	case len(g.doc.Scenes) > 0:
+		roots = g.doc.Scenes[0].Nodes
+	default:
+		// without scenes every node that is nobody's child is a root
+		child := map[int]bool{}
+		for _, n := range g.doc.Nodes {
+			for _, c := range n.Children {
+				child[c] = true
+			}
+		}
+		for i := range g.doc.Nodes {
+			if !child[i] {
+				roots = append(roots, i)
+			}
+		}
+	}
+
+	visited := map[int]bool{}
+	for _, root := range roots {
+		if err := g.node(root, Identity(), visited); err != nil {
+			return nil, err
+		}
+	}
+
+	scene := &GLTFScene{Cameras: g.cameras}
+	switch len(g.meshes) {
+	case 0:
+		return nil, fmt.Errorf("scene has no meshes")
+	case 1:
+		scene.World = g.meshes[0]
+	default:
+		list := NewHittableList()
+		list.Objects = g.meshes
+		scene.World = NewBVHNode(list, 0, 1)
+	}
+	return scene, nil
+}
+
+// readGLB returns the JSON chunk of a binary glTF file and keeps the BIN
+// chunk for the first buffer.
+func (g *gltfLoader) readGLB(data []byte) ([]byte, error) {
+	if len(data) < 12 {
+		return nil, fmt.Errorf("truncated GLB header")
+	}
+	if version := binary.LittleEndian.Uint32(data[4:]); version != 2 {
+		return nil, fmt.Errorf("unsupported GLB version %d", version)
+	}
+	length := int(binary.LittleEndian.Uint32(data[8:]))
+	if length > len(data) {
+		return nil, fmt.Errorf("truncated GLB file (%d of %d bytes)", len(data), length)
+	}
+
+	var jsonChunk []byte
+	for offset := 12; offset+8 <= length; {
+		chunkLength := int(binary.LittleEndian.Uint32(data[offset:]))
+		chunkType := binary.LittleEndian.Uint32(data[offset+4:])
+		offset += 8
+		if chunkLength > length-offset {
+			return nil, fmt.Errorf("truncated GLB chunk")
+		}
+
+		chunk := data[offset : offset+chunkLength]
+		switch {
+		case chunkType == glbChunkJSON && jsonChunk == nil:
+			jsonChunk = chunk
+		case chunkType == glbChunkBIN && g.glbBIN == nil:
+			g.glbBIN = chunk
+		}
+		offset += (chunkLength + 3) &^ 3
+	}
+	if jsonChunk == nil {
+		return nil, fmt.Errorf("GLB file has no JSON chunk")
+	}
+	return jsonChunk, nil
+}
+
+func (g *gltfLoader) node(index int, parent Matrix4, visited map[int]bool) error {
+	if index < 0 || index >= len(g.doc.Nodes) {
+		return fmt.Errorf("node %d out of range", index)
+	}
+	if visited[index] {
+		return fmt.Errorf("nodes[%d]: node is used more than once", index)
+	}
+	visited[index] = true
+
+	n := g.doc.Nodes[index]
+	local, err := n.matrix()
+	if err != nil {
+		return fmt.Errorf("nodes[%d]: %v", index, err)
+	}
+	world := parent.Mul(local)
+
+	if n.Mesh != nil {
+		if err := g.mesh(*n.Mesh, world); err != nil {
+			return fmt.Errorf("nodes[%d]: %v", index, err)
+		}
+	}
+	if n.Camera != nil {
+		if err := g.camera(*n.Camera, world); err != nil {
+			return fmt.Errorf("nodes[%d]: %v", index, err)
+		}
+	}
+
+	for _, child := range n.Children {
+		if err := g.node(child, world, visited); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (n *gltfNode) matrix() (Matrix4, error) {
+	if n.Matrix != nil {
+		if len(n.Matrix) != 16 {
+			return Matrix4{}, fmt.Errorf("matrix has %d elements, want 16", len(n.Matrix))
+		}
+		// glTF stores matrices column by column
+		var m Matrix4
+		for c := 0; c < 4; c++ {
+			for r := 0; r < 4; r++ {
+				m[r][c] = n.Matrix[4*c+r]
+			}
+		}
+		return m, nil
+	}
+
+	t, r, s := []float64{0, 0, 0}, []float64{0, 0, 0, 1}, []float64{1, 1, 1}
+	if n.Translation != nil {
+		t = n.Translation
+	}
+	if n.Rotation != nil {
+		r = n.Rotation
+	}
+	if n.Scale != nil {
+		s = n.Scale
+	}
+	if len(t) != 3 || len(r) != 4 || len(s) != 3 {
+		return Matrix4{}, fmt.Errorf("translation, rotation or scale has the wrong number of elements")
+	}
+
+	x, y, z, w := r[0], r[1], r[2], r[3]
+	rot := [3][3]float64{
+		{1 - 2*(y*y+z*z), 2 * (x*y - z*w), 2 * (x*z + y*w)},
+		{2 * (x*y + z*w), 1 - 2*(x*x+z*z), 2 * (y*z - x*w)},
+		{2 * (x*z - y*w), 2 * (y*z + x*w), 1 - 2*(x*x+y*y)},
+	}
+
+	m := Identity()
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			m[i][j] = rot[i][j] * s[j]
+		}
+		m[i][3] = t[i]
+	}
+	return m, nil
+}
+
+func (g *gltfLoader) camera(index int, world Matrix4) error {
+	if index < 0 || index >= len(g.doc.Cameras) {
+		return fmt.Errorf("camera %d out of range", index)
+	}
+	cam := g.doc.Cameras[index]
+	if cam.Type != "perspective" || cam.Perspective == nil {
+		return nil
+	}
+
+	// cameras look down their local -Z with +Y up
+	lookFrom := world.MulPoint(Zero())
+	lookAt := world.MulPoint(NewVec3(0, 0, -1))
+	g.cameras = append(g.cameras, CameraParams{
+		LookFrom:    lookFrom,
+		LookAt:      lookAt,
+		VUp:         world.MulVector(NewVec3(0, 1, 0)),
+		VFOV:        cam.Perspective.YFOV * 180 / math.Pi,
+		AspectRatio: cam.Perspective.AspectRatio,
+		FocusDist:   lookAt.Sub(lookFrom).Len(),
+	})
+	return nil
+}
+
+// mesh adds the primitives of a mesh, transformed to world space, as one
+// TriangleMesh.
+func (g *gltfLoader) mesh(index int, world Matrix4) error {
+	if index < 0 || index >= len(g.doc.Meshes) {
+		return fmt.Errorf("mesh %d out of range", index)
+	}
+
+	inverse, ok := world.Inverse()
+	if !ok {
+		// nodes scaled to zero are a common way of hiding things
+		return nil
+	}
+	normalMatrix := inverse.Transpose()
+	// a mirroring transform turns the faces inside out
+	flip := world.Det3() < 0
+
+	mesh := &TriangleMesh{Materials: g.materials}
+	hasNormals, hasUVs := false, false
+	for i, p := range g.doc.Meshes[index].Primitives {
+		where := fmt.Sprintf("meshes[%d].primitives[%d]", index, i)
+
+		mode := 4
+		if p.Mode != nil {
+			mode = *p.Mode
+		}
+		if mode < 4 || mode > 6 {
+			// points and lines have no surface
+			continue
+		}
+
+		position, ok := p.Attributes["POSITION"]
+		if !ok {
+			return fmt.Errorf("%s: missing POSITION", where)
+		}
+		positions, err := g.vec3s(position)
+		if err != nil {
+			return fmt.Errorf("%s: POSITION: %v", where, err)
+		}
+
+		var normals []Vec3
+		if normal, ok := p.Attributes["NORMAL"]; ok {
+			if normals, err = g.vec3s(normal); err != nil {
+				return fmt.Errorf("%s: NORMAL: %v", where, err)
+			}
+		}
+		var uvs []Vec2
+		if uv, ok := p.Attributes["TEXCOORD_0"]; ok {
+			if uvs, err = g.vec2s(uv); err != nil {
+				return fmt.Errorf("%s: TEXCOORD_0: %v", where, err)
+			}
+		}
+		if (normals != nil && len(normals) != len(positions)) || (uvs != nil && len(uvs) != len(positions)) {
+			return fmt.Errorf("%s: attributes have different counts", where)
+		}
+
+		var indices []uint32
+		if p.Indices != nil {
+			if indices, err = g.indices(*p.Indices); err != nil {
+				return fmt.Errorf("%s: indices: %v", where, err)
+			}
+		} else {
+			indices = make([]uint32, len(positions))
+			for j := range indices {
+				indices[j] = uint32(j)
+			}
+		}
+
+		material := uint16(len(g.materials) - 1)
+		if p.Material != nil {
+			if *p.Material < 0 || *p.Material >= len(g.materials)-1 {
+				return fmt.Errorf("%s: material %d out of range", where, *p.Material)
+			}
+			material = uint16(*p.Material)
+		}
+
+		base := uint32(len(mesh.Positions))
+		for j, pos := range positions {
+			mesh.Positions = append(mesh.Positions, world.MulPoint(pos))
+
+			n := Zero()
+			if normals != nil {
+				n = normalMatrix.MulVector(normals[j])
+				hasNormals = true
+			}
+			mesh.Normals = append(mesh.Normals, n)
+
+			uv := Vec2{}
+			if uvs != nil {
+				// glTF puts the origin of texture space at the top left
+				uv = NewVec2(uvs[j][0], 1-uvs[j][1])
+				hasUVs = true
+			}
+			mesh.UVs = append(mesh.UVs, uv)
+		}
+
+		for _, tri := range gltfTriangles(mode, len(indices)) {
+			a, b, c := indices[tri[0]], indices[tri[1]], indices[tri[2]]
+			if int(a) >= len(positions) || int(b) >= len(positions) || int(c) >= len(positions) {
+				return fmt.Errorf("%s: index out of range", where)
+			}
+			if flip {
+				b, c = c, b
+			}
+			mesh.Indices = append(mesh.Indices, base+a, base+b, base+c)
+			mesh.MaterialIDs = append(mesh.MaterialIDs, material)
+		}
+	}
+
+	if len(mesh.Indices) == 0 {
+		return nil
+	}
+	if !hasNormals {
+		mesh.Normals = nil
+	}
+	if !hasUVs {
+		mesh.UVs = nil
+	}
+	if err := mesh.Build(); err != nil {
+		return fmt.Errorf("meshes[%d]: %v", index, err)
+	}
+	g.meshes = append(g.meshes, mesh)
+	return nil
+}
+
+// gltfTriangles lists the corners of the triangles of a primitive with n
+// indices: mode 4 is a triangle list, 5 a strip and 6 a fan.
+func gltfTriangles(mode, n int) [][3]int {
+	var tris [][3]int
+	switch mode {
+	case 4:
+		for i := 0; i+2 < n; i += 3 {
+			tris = append(tris, [3]int{i, i + 1, i + 2})
+		}
+	case 5:
+		for i := 0; i+2 < n; i++ {
+			if i%2 == 0 {
+				tris = append(tris, [3]int{i, i + 1, i + 2})
+			} else {
+				tris = append(tris, [3]int{i + 1, i, i + 2})
+			}
+		}
+	case 6:
+		for i := 1; i+1 < n; i++ {
+			tris = append(tris, [3]int{0, i, i + 1})
+		}
+	}
+	return tris
+}
+
+func (g *gltfLoader) material(m gltfMaterial) (Material, error) {
+	base := NewVec3(1, 1, 1)
+	if f := m.PBR.BaseColorFactor; f != nil {
+		if len(f) != 4 {
+			return nil, fmt.Errorf("baseColorFactor has %d elements, want 4", len(f))
+		}
+		base = NewVec3(f[0], f[1], f[2])
+	}
+
+	emissive := Zero()
+	if f := m.EmissiveFactor; f != nil {
+		if len(f) != 3 {
+			return nil, fmt.Errorf("emissiveFactor has %d elements, want 3", len(f))
+		}
+		emissive = NewVec3(f[0], f[1], f[2])
+	}
+	if ext := m.Extensions.EmissiveStrength; ext != nil && ext.EmissiveStrength != nil {
+		emissive = emissive.Mulf(*ext.EmissiveStrength)
+	}
+	if maxComponent(emissive) > 0 {
+		tex, err := g.tintedTexture(m.EmissiveTexture, emissive)
+		if err != nil {
+			return nil, err
+		}
+		return NewDiffuseLight(tex), nil
+	}
+
+	if ext := m.Extensions.Transmission; ext != nil && ext.TransmissionFactor > 0 {
+		ior := 1.5
+		if ext := m.Extensions.IOR; ext != nil && ext.IOR != nil {
+			ior = *ext.IOR
+		}
+		return NewDielectric(ior), nil
+	}
+
+	metallic, roughness := 1.0, 1.0
+	if m.PBR.MetallicFactor != nil {
+		metallic = *m.PBR.MetallicFactor
+	}
+	if m.PBR.RoughnessFactor != nil {
+		roughness = *m.PBR.RoughnessFactor
+	}
+	if metallic >= 0.5 {
+		return NewMetal(base, Clamp(roughness, 0, 1)), nil
+	}
+
+	tex, err := g.tintedTexture(m.PBR.BaseColorTexture, base)
+	if err != nil {
+		return nil, err
+	}
+	return NewLambertian(tex), nil
+}
+
+// tintedTexture is the texture of info scaled by factor, or just factor
+// without a texture.
+func (g *gltfLoader) tintedTexture(info *gltfTextureInfo, factor Color) (Texture, error) {
+	if info == nil {
+		return NewSolidColor(factor[0], factor[1], factor[2]), nil
+	}
+
+	tex, err := g.texture(info.Index)
+	if err != nil {
+		return nil, err
+	}
+	if factor == NewVec3(1, 1, 1) {
+		return tex, nil
+	}
+	return &gltfTintedTexture{Texture: tex, Factor: factor}, nil
+}
+
+type gltfTintedTexture struct {
+	Texture Texture
+	Factor  Color
+}
+
+func (t *gltfTintedTexture) Value(u, v float64, p Point3) Color {
+	return t.Texture.Value(u, v, p).Mul(t.Factor)
+}
+
+func (g *gltfLoader) texture(index int) (Texture, error) {
+	if tex, ok := g.textures[index]; ok {
+		return tex, nil
+	}
+	if index < 0 || index >= len(g.doc.Textures) {
+		return nil, fmt.Errorf("texture %d out of range", index)
+	}
+	source := g.doc.Textures[index].Source
+	if source == nil || *source < 0 || *source >= len(g.doc.Images) {
+		return nil, fmt.Errorf("textures[%d]: missing or invalid source", index)
+	}
+
+	var data []byte
+	var err error
+	img := g.doc.Images[*source]
+	if img.BufferView != nil {
+		data, err = g.bufferView(*img.BufferView)
+	} else {
+		data, err = g.uri(img.URI)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("images[%d]: %v", *source, err)
+	}
+
+	decoded, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("images[%d]: %v", *source, err)
+	}
+
+	tex := NewImageTextureFromImage(decoded)
+	tex.Name = fmt.Sprintf("%s: images[%d]", g.file, *source)
+	sampler := gltfSampler{}
+	if i := g.doc.Textures[index].Sampler; i != nil {
+		if *i < 0 || *i >= len(g.doc.Samplers) {
+			return nil, fmt.Errorf("textures[%d]: sampler %d out of range", index, *i)
+		}
+		sampler = g.doc.Samplers[*i]
+	}
+	if sampler.MagFilter == gltfNearest {
+		tex.Filter = TextureFilterNearest
+	} else {
+		tex.Filter = TextureFilterBilinear
+	}
+	tex.WrapU = gltfWrap(sampler.WrapS)
+	tex.WrapV = gltfWrap(sampler.WrapT)
+
+	g.textures[index] = tex
+	return tex, nil
+}
+
+// gltfWrap maps a sampler wrap mode, where a missing one means repeat.
+func gltfWrap(mode int) TextureWrap {
+	switch mode {
+	case gltfClampToEdge:
+		return TextureWrapClamp
+	case gltfMirroredRepeat:
+		return TextureWrapMirror
+	}
+	return TextureWrapRepeat
+}
+
+// uri reads a data URI or a file relative to the glTF file.
+func (g *gltfLoader) uri(uri string) ([]byte, error) {
+	if strings.HasPrefix(uri, "data:") {
+		comma := strings.IndexByte(uri, ',')
+		if comma < 0 || !strings.HasSuffix(uri[:comma], ";base64") {
+			return nil, fmt.Errorf("unsupported data URI")
+		}
+		return base64.StdEncoding.DecodeString(uri[comma+1:])
+	}
+	if uri == "" {
+		return nil, fmt.Errorf("missing uri")
+	}
+
+	name, err := url.PathUnescape(uri)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadFile(filepath.Join(g.dir, filepath.FromSlash(name)))
+}
+
+func (g *gltfLoader) buffer(index int) ([]byte, error) {
+	if data, ok := g.buffers[index]; ok {
+		return data, nil
+	}
+	if index < 0 || index >= len(g.doc.Buffers) {
+		return nil, fmt.Errorf("buffer %d out of range", index)
+	}
+
+	b := g.doc.Buffers[index]
+	var data []byte
+	if b.URI == "" && index == 0 && g.glbBIN != nil {
+		data = g.glbBIN
+	} else {
+		var err error
+		if data, err = g.uri(b.URI); err != nil {
+			return nil, fmt.Errorf("buffers[%d]: %v", index, err)
+		}
+	}
+	if len(data) < b.ByteLength {
+		return nil, fmt.Errorf("buffers[%d]: %d bytes, want %d", index, len(data), b.ByteLength)
+	}
+
+	g.buffers[index] = data
+	return data, nil
+}
+
+func (g *gltfLoader) bufferView(index int) ([]byte, error) {
+	if index < 0 || index >= len(g.doc.BufferViews) {
+		return nil, fmt.Errorf("buffer view %d out of range", index)
+	}
+	view := g.doc.BufferViews[index]
+
+	data, err := g.buffer(view.Buffer)
+	if err != nil {
+		return nil, err
+	}
+	if view.ByteOffset < 0 || view.ByteLength < 0 || view.ByteOffset > len(data) || view.ByteLength > len(data)-view.ByteOffset {
+		return nil, fmt.Errorf("bufferViews[%d]: range outside its buffer", index)
+	}
+	return data[view.ByteOffset : view.ByteOffset+view.ByteLength], nil
+}
+
+// accessor reads the elements of an accessor of the given type as float64,
+// converting and normalising integer components as needed.
+func (g *gltfLoader) accessor(index int, typ string) ([]float64, error) {
+	if index < 0 || index >= len(g.doc.Accessors) {
+		return nil, fmt.Errorf("accessor %d out of range", index)
+	}
+	acc := g.doc.Accessors[index]
+	if acc.Type != typ {
+		return nil, fmt.Errorf("accessors[%d]: type %s, want %s", index, acc.Type, typ)
+	}
+	if acc.Sparse != nil {
+		return nil, fmt.Errorf("accessors[%d]: sparse accessors are not supported", index)
+	}
+	if acc.Count < 0 {
+		return nil, fmt.Errorf("accessors[%d]: negative count %d", index, acc.Count)
+	}
+	if acc.ByteOffset < 0 {
+		return nil, fmt.Errorf("accessors[%d]: negative byteOffset %d", index, acc.ByteOffset)
+	}
+
+	components := map[string]int{"SCALAR": 1, "VEC2": 2, "VEC3": 3, "VEC4": 4}[typ]
+	if acc.BufferView == nil {
+		// an accessor without a buffer view is all zeros
+		if acc.Count > gltfMaxZeroElements {
+			return nil, fmt.Errorf("accessors[%d]: count %d is too large without a buffer view", index, acc.Count)
+		}
+		return make([]float64, acc.Count*components), nil
+	}
+
+	size := map[int]int{5120: 1, 5121: 1, 5122: 2, 5123: 2, 5125: 4, 5126: 4}[acc.ComponentType]
+	if size == 0 {
+		return nil, fmt.Errorf("accessors[%d]: unknown component type %d", index, acc.ComponentType)
+	}
+
+	data, err := g.bufferView(*acc.BufferView)
+	if err != nil {
+		return nil, err
+	}
+	stride := g.doc.BufferViews[*acc.BufferView].ByteStride
+	if stride < 0 {
+		return nil, fmt.Errorf("accessors[%d]: negative byteStride %d in bufferViews[%d]", index, stride, *acc.BufferView)
+	}
+	if stride == 0 {
+		stride = size * components
+	}
+	// each term is at most the size of the view before they are added, so
+	// the sum cannot overflow
+	inView := acc.ByteOffset <= len(data) && acc.Count <= len(data) && (acc.Count <= 1 || stride <= len(data))
+	if !inView || acc.Count > 0 && acc.ByteOffset+(acc.Count-1)*stride+size*components > len(data) {
+		return nil, fmt.Errorf("accessors[%d]: range outside its buffer view", index)
+	}
+
+	out := make([]float64, acc.Count*components)
+
+	for i := 0; i < acc.Count; i++ {
+		element := data[acc.ByteOffset+i*stride:]
+		for c := 0; c < components; c++ {
+			b := element[c*size:]
+			var x float64
+			switch acc.ComponentType {
+			case 5120:
+				x = float64(int8(b[0]))
+				if acc.Normalized {
+					x = math.Max(x/127, -1)
+				}
+			case 5121:
+				x = float64(b[0])
+				if acc.Normalized {
+					x /= 255
+				}
+			case 5122:
+				x = float64(int16(binary.LittleEndian.Uint16(b)))
+				if acc.Normalized {
+					x = math.Max(x/32767, -1)
+				}
+			case 5123:
+				x = float64(binary.LittleEndian.Uint16(b))
+				if acc.Normalized {
+					x /= 65535
+				}
+			case 5125:
+				x = float64(binary.LittleEndian.Uint32(b))
+			case 5126:
+				x = float64(math.Float32frombits(binary.LittleEndian.Uint32(b)))
+			}
+			out[i*components+c] = x
+		}
+	}
+	return out, nil
+}
+
+func (g *gltfLoader) vec3s(index int) ([]Vec3, error) {
+	data, err := g.accessor(index, "VEC3")
+	if err != nil {
+		return nil, err
+	}
+	vs := make([]Vec3, len(data)/3)
+	for i := range vs {
+		vs[i] = NewVec3(data[3*i], data[3*i+1], data[3*i+2])
+	}
+	return vs, nil
+}
+
+func (g *gltfLoader) vec2s(index int) ([]Vec2, error) {
+	data, err := g.accessor(index, "VEC2")
+	if err != nil {
+		return nil, err
+	}
+	vs := make([]Vec2, len(data)/2)
+	for i := range vs {
+		vs[i] = NewVec2(data[2*i], data[2*i+1])
+	}
+	return vs, nil
+}
+
+func (g *gltfLoader) indices(index int) ([]uint32, error) {
+	data, err := g.accessor(index, "SCALAR")
+	if err != nil {
+		return nil, err
+	}
+	indices := make([]uint32, len(data))
+	for i, x := range data {
+		indices[i] = uint32(x)
+	}
+	return indices, nil
+}
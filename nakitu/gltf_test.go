@@ -0,0 +1,181 @@
+package nakitu
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"io/ioutil"
+	"math"
+	"path/filepath"
+	"testing"
+)
+
+// gltfTriangle is a document with one triangle in one node. Its buffer is
+// embedded unless bin is true, when it is left for a GLB BIN chunk.
+func gltfTriangle(bin bool) (map[string]interface{}, []byte) {
+	var buf bytes.Buffer
+	for _, x := range []float32{0, 0, 0, 1, 0, 0, 0, 1, 0} {
+		binary.Write(&buf, binary.LittleEndian, math.Float32bits(x))
+	}
+
+	buffer := map[string]interface{}{"byteLength": buf.Len()}
+	if !bin {
+		buffer["uri"] = "data:application/octet-stream;base64," + base64.StdEncoding.EncodeToString(buf.Bytes())
+	}
+	doc := map[string]interface{}{
+		"asset":   map[string]interface{}{"version": "2.0"},
+		"scene":   0,
+		"scenes":  []interface{}{map[string]interface{}{"nodes": []interface{}{0}}},
+		"nodes":   []interface{}{map[string]interface{}{"mesh": 0}},
+		"meshes":  []interface{}{map[string]interface{}{"primitives": []interface{}{map[string]interface{}{"attributes": map[string]interface{}{"POSITION": 0}}}}},
+		"buffers": []interface{}{buffer},
+		"bufferViews": []interface{}{
+			map[string]interface{}{"buffer": 0, "byteLength": buf.Len()},
+		},
+		"accessors": []interface{}{
+			map[string]interface{}{"bufferView": 0, "componentType": 5126, "count": 3, "type": "VEC3"},
+		},
+	}
+	return doc, buf.Bytes()
+}
+
+func gltfIndex(doc map[string]interface{}, key string, i int) map[string]interface{} {
+	return doc[key].([]interface{})[i].(map[string]interface{})
+}
+
+func TestLoadGLTFMalformed(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(doc map[string]interface{})
+		want   string
+	}{
+		{
+			name:   "negative count",
+			change: func(doc map[string]interface{}) { gltfIndex(doc, "accessors", 0)["count"] = -3 },
+			want:   "negative count",
+		},
+		{
+			name:   "oversized count",
+			change: func(doc map[string]interface{}) { gltfIndex(doc, "accessors", 0)["count"] = 1e15 },
+			want:   "outside its buffer view",
+		},
+		{
+			name: "oversized count without a buffer view",
+			change: func(doc map[string]interface{}) {
+				delete(gltfIndex(doc, "accessors", 0), "bufferView")
+				gltfIndex(doc, "accessors", 0)["count"] = 1e15
+			},
+			want: "too large",
+		},
+		{
+			name:   "negative byte offset",
+			change: func(doc map[string]interface{}) { gltfIndex(doc, "accessors", 0)["byteOffset"] = -12 },
+			want:   "negative byteOffset",
+		},
+		{
+			name:   "oversized byte offset",
+			change: func(doc map[string]interface{}) { gltfIndex(doc, "accessors", 0)["byteOffset"] = 1 << 62 },
+			want:   "outside its buffer view",
+		},
+		{
+			name:   "negative byte stride",
+			change: func(doc map[string]interface{}) { gltfIndex(doc, "bufferViews", 0)["byteStride"] = -12 },
+			want:   "negative byteStride",
+		},
+		{
+			name:   "oversized byte stride",
+			change: func(doc map[string]interface{}) { gltfIndex(doc, "bufferViews", 0)["byteStride"] = 1 << 62 },
+			want:   "outside its buffer view",
+		},
+		{
+			name: "oversized buffer view",
+			change: func(doc map[string]interface{}) {
+				gltfIndex(doc, "bufferViews", 0)["byteOffset"] = 1 << 62
+				gltfIndex(doc, "bufferViews", 0)["byteLength"] = 1 << 62
+			},
+			want: "outside its buffer",
+		},
+		{
+			name:   "short buffer",
+			change: func(doc map[string]interface{}) { gltfIndex(doc, "buffers", 0)["byteLength"] = 1000 },
+			want:   "buffers[0]",
+		},
+		{
+			name:   "buffer view out of range",
+			change: func(doc map[string]interface{}) { gltfIndex(doc, "accessors", 0)["bufferView"] = 7 },
+			want:   "buffer view 7 out of range",
+		},
+		{
+			name:   "mesh out of range",
+			change: func(doc map[string]interface{}) { gltfIndex(doc, "nodes", 0)["mesh"] = 3 },
+			want:   "mesh",
+		},
+		{
+			name:   "node cycle",
+			change: func(doc map[string]interface{}) { gltfIndex(doc, "nodes", 0)["children"] = []interface{}{0} },
+			want:   "nodes[0]",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc, _ := gltfTriangle(false)
+			tt.change(doc)
+			data, err := json.Marshal(doc)
+			if err != nil {
+				t.Fatal(err)
+			}
+			name := filepath.Join(t.TempDir(), "a.gltf")
+			if err := ioutil.WriteFile(name, data, 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			loadFails(t, tt.want, func() error {
+				_, err := LoadGLTF(name)
+				return err
+			})
+		})
+	}
+}
+
+func TestLoadGLBTruncated(t *testing.T) {
+	doc, bin := gltfTriangle(true)
+	js, err := json.Marshal(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for len(js)%4 != 0 {
+		js = append(js, ' ')
+	}
+
+	var glb bytes.Buffer
+	w := func(x uint32) { binary.Write(&glb, binary.LittleEndian, x) }
+	w(glbMagic)
+	w(2)
+	w(uint32(12 + 8 + len(js) + 8 + len(bin)))
+	w(uint32(len(js)))
+	w(glbChunkJSON)
+	glb.Write(js)
+	w(uint32(len(bin)))
+	w(glbChunkBIN)
+	glb.Write(bin)
+
+	dir := t.TempDir()
+	name := filepath.Join(dir, "a.glb")
+	if err := ioutil.WriteFile(name, glb.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadGLTF(name); err != nil {
+		t.Fatalf("whole file: %v", err)
+	}
+
+	for n := 0; n < glb.Len(); n++ {
+		if err := ioutil.WriteFile(name, glb.Bytes()[:n], 0644); err != nil {
+			t.Fatal(err)
+		}
+		loadFails(t, "a.glb", func() error {
+			_, err := LoadGLTF(name)
+			return err
+		})
+	}
+}
@@ -1,8 +1,8 @@
 package nakitu
 
 import (
+	"errors"
 	"math"
-	"math/rand"
 )
 
 type HitRecord struct {
@@ -13,6 +13,13 @@ type HitRecord struct {
 	U         float64
 	V         float64
 	frontFace bool
+
+	// Mesh is the TriangleMesh that was hit, if any, and Face and Bary the
+	// face and the barycentric weights of its corners, for textures that
+	// read per-vertex data. Other surfaces set Mesh to nil.
+	Mesh *TriangleMesh
+	Face int
+	Bary Vec3
 }
 
 func (hr *HitRecord) SetFaceNormal(r *Ray, outwardNormal Vec3) {
@@ -24,6 +31,19 @@ func (hr *HitRecord) SetFaceNormal(r *Ray, outwardNormal Vec3) {
 	}
 }
 
+// SetShadingNormal sets an interpolated normal. Which side was hit is still
+// decided by the geometric normal, and the shading normal is turned to the
+// same side so that the two never disagree.
+func (hr *HitRecord) SetShadingNormal(r *Ray, geometric, shading Vec3) {
+	hr.SetFaceNormal(r, geometric)
+
+	shading = shading.Unit()
+	if shading.Dot(hr.Normal) < 0 {
+		shading = shading.Neg()
+	}
+	hr.Normal = shading
+}
+
 type HittableList struct {
 	Objects []Hittable
 }
@@ -40,13 +60,13 @@ func (hl *HittableList) Add(object Hittable) {
 	hl.Objects = append(hl.Objects, object)
 }
 
-func (hl *HittableList) Hit(r *Ray, tMin, tMax float64, rec *HitRecord) bool {
+func (hl *HittableList) Hit(r *Ray, tMin, tMax float64, rec *HitRecord, rnd *Rand) bool {
 	var tempRec HitRecord
 	hitAnything := false
 	closestSoFar := tMax
 
 	for _, object := range hl.Objects {
-		if object.Hit(r, tMin, closestSoFar, &tempRec) {
+		if object.Hit(r, tMin, closestSoFar, &tempRec, rnd) {
 			hitAnything = true
 			closestSoFar = tempRec.T
 			*rec = tempRec
@@ -62,18 +82,22 @@ func (hl *HittableList) BoundingBox(time0, time1 float64, outputBox *AABB) bool
 	}
 
 	var tempBox AABB
-	for _, object := range hl.Objects {
+	for i, object := range hl.Objects {
 		if !object.BoundingBox(time0, time1, &tempBox) {
 			return false
 		}
-		outputBox = SurroundingBox(outputBox, &tempBox)
+		if i == 0 {
+			*outputBox = tempBox
+		} else {
+			*outputBox = *SurroundingBox(outputBox, &tempBox)
+		}
 	}
 
 	return true
 }
 
 type Hittable interface {
-	Hit(r *Ray, tMin, tMax float64, rec *HitRecord) bool
+	Hit(r *Ray, tMin, tMax float64, rec *HitRecord, rnd *Rand) bool
 	BoundingBox(time0, time1 float64, outputBox *AABB) bool
 }
 
@@ -87,7 +111,7 @@ func NewSphere(center Point3, radius float64, mat Material) *Sphere {
 	return &Sphere{Center: center, Radius: radius, Mat: mat}
 }
 
-func (s *Sphere) Hit(r *Ray, tMin, tMax float64, rec *HitRecord) bool {
+func (s *Sphere) Hit(r *Ray, tMin, tMax float64, rec *HitRecord, rnd *Rand) bool {
 	oc := r.Origin.Sub(s.Center)
 	a := r.Dir.LenSquared()
 	halfB := oc.Dot(r.Dir)
@@ -114,6 +138,7 @@ func (s *Sphere) Hit(r *Ray, tMin, tMax float64, rec *HitRecord) bool {
 	rec.SetFaceNormal(r, outwardNormal)
 	getSphereUV(outwardNormal, &rec.U, &rec.V)
 	rec.Mat = s.Mat
+	rec.Mesh = nil
 
 	return true
 }
@@ -154,7 +179,7 @@ func NewMovingSphere(center0, center1 Point3, time0, time1 float64, radius float
 	}
 }
 
-func (s *MovingSphere) Hit(r *Ray, tMin, tMax float64, rec *HitRecord) bool {
+func (s *MovingSphere) Hit(r *Ray, tMin, tMax float64, rec *HitRecord, rnd *Rand) bool {
 	oc := r.Origin.Sub(s.Center(r.Time))
 	a := r.Dir.LenSquared()
 	halfB := oc.Dot(r.Dir)
@@ -180,6 +205,7 @@ func (s *MovingSphere) Hit(r *Ray, tMin, tMax float64, rec *HitRecord) bool {
 	outwardNormal := rec.Point.Sub(s.Center(r.Time)).Divf(s.Radius)
 	rec.SetFaceNormal(r, outwardNormal)
 	rec.Mat = s.Mat
+	rec.Mesh = nil
 	return true
 }
 
@@ -218,7 +244,7 @@ func NewXYRect(x0, y0, x1, y1, k float64, mat Material) *XYRect {
 	}
 }
 
-func (s *XYRect) Hit(r *Ray, tMin, tMax float64, rec *HitRecord) bool {
+func (s *XYRect) Hit(r *Ray, tMin, tMax float64, rec *HitRecord, rnd *Rand) bool {
 	t := (s.K - r.Origin.Z()) / r.Dir.Z()
 	if t < tMin || t > tMax {
 		return false
@@ -234,6 +260,7 @@ func (s *XYRect) Hit(r *Ray, tMin, tMax float64, rec *HitRecord) bool {
 	outwardNormal := NewVec3(0, 0, 1)
 	rec.SetFaceNormal(r, outwardNormal)
 	rec.Mat = s.Mat
+	rec.Mesh = nil
 	rec.Point = r.At(t)
 	return true
 }
@@ -264,7 +291,7 @@ func NewXZRect(x0, z0, x1, z1, k float64, mat Material) *XZRect {
 	}
 }
 
-func (s *XZRect) Hit(r *Ray, tMin, tMax float64, rec *HitRecord) bool {
+func (s *XZRect) Hit(r *Ray, tMin, tMax float64, rec *HitRecord, rnd *Rand) bool {
 	t := (s.K - r.Origin.Y()) / r.Dir.Y()
 	if t < tMin || t > tMax {
 		return false
@@ -280,6 +307,7 @@ func (s *XZRect) Hit(r *Ray, tMin, tMax float64, rec *HitRecord) bool {
 	outwardNormal := NewVec3(0, 1, 0)
 	rec.SetFaceNormal(r, outwardNormal)
 	rec.Mat = s.Mat
+	rec.Mesh = nil
 	rec.Point = r.At(t)
 	return true
 }
@@ -310,7 +338,7 @@ func NewYZRect(y0, z0, y1, z1, k float64, mat Material) *YZRect {
 	}
 }
 
-func (s *YZRect) Hit(r *Ray, tMin, tMax float64, rec *HitRecord) bool {
+func (s *YZRect) Hit(r *Ray, tMin, tMax float64, rec *HitRecord, rnd *Rand) bool {
 	t := (s.K - r.Origin.X()) / r.Dir.X()
 	if t < tMin || t > tMax {
 		return false
@@ -326,6 +354,7 @@ func (s *YZRect) Hit(r *Ray, tMin, tMax float64, rec *HitRecord) bool {
 	outwardNormal := NewVec3(1, 0, 0)
 	rec.SetFaceNormal(r, outwardNormal)
 	rec.Mat = s.Mat
+	rec.Mesh = nil
 	rec.Point = r.At(t)
 	return true
 }
@@ -363,8 +392,8 @@ func NewBox(p0, p1 Point3, mat Material) *Box {
 	return box
 }
 
-func (b *Box) Hit(r *Ray, tMin, tMax float64, rec *HitRecord) bool {
-	return b.Sides.Hit(r, tMin, tMax, rec)
+func (b *Box) Hit(r *Ray, tMin, tMax float64, rec *HitRecord, rnd *Rand) bool {
+	return b.Sides.Hit(r, tMin, tMax, rec, rnd)
 }
 
 func (b *Box) BoundingBox(time0, time1 float64, outputBox *AABB) bool {
@@ -387,9 +416,9 @@ func NewTranslate(obj Hittable, disp Vec3) *Translate {
 	}
 }
 
-func (t *Translate) Hit(r *Ray, tMin, tMax float64, rec *HitRecord) bool {
+func (t *Translate) Hit(r *Ray, tMin, tMax float64, rec *HitRecord, rnd *Rand) bool {
 	movedR := NewRay(r.Origin.Sub(t.Offset), r.Dir, r.Time)
-	if !t.Obj.Hit(movedR, tMin, tMax, rec) {
+	if !t.Obj.Hit(movedR, tMin, tMax, rec, rnd) {
 		return false
 	}
 
@@ -400,7 +429,7 @@ func (t *Translate) Hit(r *Ray, tMin, tMax float64, rec *HitRecord) bool {
 }
 
 func (t *Translate) BoundingBox(time0, time1 float64, outputBox *AABB) bool {
-	if t.Obj.BoundingBox(time0, time1, outputBox) {
+	if !t.Obj.BoundingBox(time0, time1, outputBox) {
 		return false
 	}
 
@@ -457,7 +486,7 @@ func NewRotateY(obj Hittable, angle float64) *RotateY {
 	return r
 }
 
-func (ry *RotateY) Hit(r *Ray, tMin, tMax float64, rec *HitRecord) bool {
+func (ry *RotateY) Hit(r *Ray, tMin, tMax float64, rec *HitRecord, rnd *Rand) bool {
 	origin := r.Origin
 	dir := r.Dir
 
@@ -469,7 +498,7 @@ func (ry *RotateY) Hit(r *Ray, tMin, tMax float64, rec *HitRecord) bool {
 
 	rotatedR := NewRay(origin, dir, r.Time)
 
-	if !ry.Obj.Hit(rotatedR, tMin, tMax, rec) {
+	if !ry.Obj.Hit(rotatedR, tMin, tMax, rec, rnd) {
 		return false
 	}
 
@@ -493,6 +522,97 @@ func (r *RotateY) BoundingBox(time0, time1 float64, outputBox *AABB) bool {
 	return r.hasBox
 }
 
+// Transform places an object with an affine matrix. Rays are brought into
+// object space with the inverse and normals back out with its transpose.
+type Transform struct {
+	Obj     Hittable
+	Matrix  Matrix4
+	Inverse Matrix4
+	normal  Matrix4
+}
+
+// NewTransform fails if m is singular, since rays could not be brought into
+// object space.
+func NewTransform(obj Hittable, m Matrix4) (*Transform, error) {
+	inv, ok := m.Inverse()
+	if !ok {
+		return nil, errors.New("transform matrix is singular")
+	}
+
+	return &Transform{
+		Obj:     obj,
+		Matrix:  m,
+		Inverse: inv,
+		normal:  inv.Transpose(),
+	}, nil
+}
+
+func (t *Transform) Hit(r *Ray, tMin, tMax float64, rec *HitRecord, rnd *Rand) bool {
+	// the direction is not normalised, so ray parameters are the same in
+	// both spaces
+	objectR := NewRay(t.Inverse.MulPoint(r.Origin), t.Inverse.MulVector(r.Dir), r.Time)
+	if !t.Obj.Hit(objectR, tMin, tMax, rec, rnd) {
+		return false
+	}
+
+	// a linear map keeps the sign of the normal against the ray, so which
+	// side was hit does not change
+	rec.Point = t.Matrix.MulPoint(rec.Point)
+	rec.Normal = t.normal.MulVector(rec.Normal).Unit()
+
+	return true
+}
+
+func (t *Transform) BoundingBox(time0, time1 float64, outputBox *AABB) bool {
+	if !t.Obj.BoundingBox(time0, time1, outputBox) {
+		return false
+	}
+	*outputBox = *t.Matrix.TransformBox(outputBox)
+	return true
+}
+
+// Instance places shared geometry with a transform and, if Mat is set, another
+// material. The geometry should have its own acceleration structure, such as
+// a BVHNode or a TriangleMesh; a BVHNode over the instances then makes a
+// two-level hierarchy where each copy costs little more than its matrices.
+type Instance struct {
+	Transform
+	Mat    Material
+	hasBox bool
+	box    AABB
+}
+
+func NewInstance(geometry Hittable, m Matrix4, mat Material) (*Instance, error) {
+	t, err := NewTransform(geometry, m)
+	if err != nil {
+		return nil, err
+	}
+
+	i := &Instance{
+		Transform: *t,
+		Mat:       mat,
+	}
+	i.hasBox = i.Transform.BoundingBox(0, 1, &i.box)
+	return i, nil
+}
+
+func (i *Instance) Hit(r *Ray, tMin, tMax float64, rec *HitRecord, rnd *Rand) bool {
+	if !i.Transform.Hit(r, tMin, tMax, rec, rnd) {
+		return false
+	}
+	if i.Mat != nil {
+		rec.Mat = i.Mat
+	}
+	return true
+}
+
+// BoundingBox returns the box computed when the instance was made, which keeps
+// building a BVH over many instances cheap.
+func (i *Instance) BoundingBox(time0, time1 float64, outputBox *AABB) bool {
+	*outputBox = i.box
+	return i.hasBox
+}
+
 type ConstantMedium struct {
 	Boundary      Hittable
 	PhaseFunction Material
@@ -507,12 +627,12 @@ func NewConstantMedium(b Hittable, d float64, tex Texture) *ConstantMedium {
 	}
 }
 
-func (cm *ConstantMedium) Hit(r *Ray, tMin, tMax float64, rec *HitRecord) bool {
+func (cm *ConstantMedium) Hit(r *Ray, tMin, tMax float64, rec *HitRecord, rnd *Rand) bool {
 	var rec1, rec2 HitRecord
-	if !cm.Boundary.Hit(r, math.Inf(-1), math.Inf(1), &rec1) {
+	if !cm.Boundary.Hit(r, math.Inf(-1), math.Inf(1), &rec1, rnd) {
 		return false
 	}
-	if !cm.Boundary.Hit(r, rec1.T+0.0001, math.Inf(1), &rec2) {
+	if !cm.Boundary.Hit(r, rec1.T+0.0001, math.Inf(1), &rec2, rnd) {
 		return false
 	}
 
@@ -533,7 +653,7 @@ func (cm *ConstantMedium) Hit(r *Ray, tMin, tMax float64, rec *HitRecord) bool {
 
 	rayLength := r.Dir.Len()
 	distanceInsideBoundary := (rec2.T - rec1.T) * rayLength
-	hitDistance := cm.negInvDensity * math.Log(rand.Float64())
+	hitDistance := cm.negInvDensity * math.Log(rnd.Float64())
 
 	if hitDistance > distanceInsideBoundary {
 		return false
@@ -545,6 +665,7 @@ func (cm *ConstantMedium) Hit(r *Ray, tMin, tMax float64, rec *HitRecord) bool {
 	rec.Normal = NewVec3(1, 0, 0)
 	rec.frontFace = true
 	rec.Mat = cm.PhaseFunction
+	rec.Mesh = nil
 
 	return true
 }
@@ -1,8 +1,15 @@
 package nakitu
 
 import (
+	"bufio"
 	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
 	"io"
+	"path/filepath"
+	"strings"
 )
 
 type Image struct {
@@ -17,6 +24,73 @@ func NewRGB(r, g, b int) RGB {
 	return RGB{r, g, b}
 }
 
+type Format int
+
+const (
+	FormatPPM Format = iota
+	FormatBinaryPPM
+	FormatPNG
+	FormatJPEG
+	FormatHDR
+	FormatPFM
+)
+
+const jpegQuality = 95
+
+func (f Format) String() string {
+	switch f {
+	case FormatPPM:
+		return "ppm"
+	case FormatBinaryPPM:
+		return "ppm-binary"
+	case FormatPNG:
+		return "png"
+	case FormatJPEG:
+		return "jpeg"
+	case FormatHDR:
+		return "hdr"
+	case FormatPFM:
+		return "pfm"
+	}
+	return fmt.Sprintf("Format(%d)", int(f))
+}
+
+func ParseFormat(name string) (Format, error) {
+	switch strings.ToLower(name) {
+	case "ppm", "p3":
+		return FormatPPM, nil
+	case "ppm-binary", "p6":
+		return FormatBinaryPPM, nil
+	case "png":
+		return FormatPNG, nil
+	case "jpeg", "jpg":
+		return FormatJPEG, nil
+	case "hdr", "rgbe":
+		return FormatHDR, nil
+	case "pfm":
+		return FormatPFM, nil
+	}
+	return 0, fmt.Errorf("unknown image format %q", name)
+}
+
+// FormatFromName picks a format from the file extension. "-" (stdout) is
+// written as ASCII PPM.
+func FormatFromName(name string) (Format, error) {
+	if name == "-" {
+		return FormatPPM, nil
+	}
+
+	ext := filepath.Ext(name)
+	if ext == "" {
+		return 0, fmt.Errorf("cannot infer image format of %q", name)
+	}
+	return ParseFormat(ext[1:])
+}
+
+func (f Format) IsHDR() bool {
+	return f == FormatHDR || f == FormatPFM
+}
+
 func NewImage(width, height int) *Image {
 	pixels := make([]RGB, width*height)
 	return &Image{Width: width, Height: height, Pixels: pixels}
@@ -27,12 +101,51 @@ func (i *Image) SetPixel(x, y int, color RGB) {
 	i.Pixels[index] = color
 }
 
-func (i *Image) Write(w io.Writer) {
-	fmt.Fprintln(w, "P3")
-	fmt.Fprintf(w, "%d %d\n", i.Width, i.Height)
-	fmt.Fprintln(w, "255")
+func (i *Image) RGBA() *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, i.Width, i.Height))
+	for y := 0; y < i.Height; y++ {
+		for x := 0; x < i.Width; x++ {
+			p := i.Pixels[x+y*i.Width]
+			img.SetRGBA(x, y, color.RGBA{R: uint8(p[0]), G: uint8(p[1]), B: uint8(p[2]), A: 255})
+		}
+	}
+	return img
+}
+
+func (i *Image) Encode(w io.Writer, format Format) error {
+	switch format {
+	case FormatPPM:
+		return i.Write(w)
+	case FormatBinaryPPM:
+		return i.writeBinary(w)
+	case FormatPNG:
+		return png.Encode(w, i.RGBA())
+	case FormatJPEG:
+		return jpeg.Encode(w, i.RGBA(), &jpeg.Options{Quality: jpegQuality})
+	}
+	return fmt.Errorf("unsupported image format %v", format)
+}
+
+func (i *Image) Write(w io.Writer) error {
+	buf := bufio.NewWriter(w)
+	fmt.Fprintln(buf, "P3")
+	fmt.Fprintf(buf, "%d %d\n", i.Width, i.Height)
+	fmt.Fprintln(buf, "255")
 
 	for _, pixel := range i.Pixels {
-		fmt.Fprintf(w, "%d %d %d\n", pixel[0], pixel[1], pixel[2])
+		fmt.Fprintf(buf, "%d %d %d\n", pixel[0], pixel[1], pixel[2])
 	}
+
+	return buf.Flush()
+}
+
+func (i *Image) writeBinary(w io.Writer) error {
+	buf := bufio.NewWriter(w)
+	fmt.Fprintf(buf, "P6\n%d %d\n255\n", i.Width, i.Height)
+
+	for _, pixel := range i.Pixels {
+		buf.Write([]byte{byte(pixel[0]), byte(pixel[1]), byte(pixel[2])})
+	}
+
+	return buf.Flush()
 }
@@ -0,0 +1,171 @@
+package nakitu
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+type jsonKind int
+
+const (
+	jsonNull jsonKind = iota
+	jsonBool
+	jsonNumber
+	jsonString
+	jsonArray
+	jsonObject
+)
+
+func (k jsonKind) String() string {
+	switch k {
+	case jsonNull:
+		return "null"
+	case jsonBool:
+		return "boolean"
+	case jsonNumber:
+		return "number"
+	case jsonString:
+		return "string"
+	case jsonArray:
+		return "array"
+	case jsonObject:
+		return "object"
+	}
+	return "unknown"
+}
+
+// jsonNode is a decoded JSON value that remembers the line it started on, so
+// that loaders can point at the offending line.
+type jsonNode struct {
+	Kind   jsonKind
+	Line   int
+	Bool   bool
+	Number float64
+	String string
+	Items  []*jsonNode
+	Keys   []string
+	Fields map[string]*jsonNode
+}
+
+type jsonError struct {
+	File  string
+	Line  int
+	Field string
+	Msg   string
+}
+
+func (e *jsonError) Error() string {
+	if e.Field == "" {
+		return fmt.Sprintf("%s:%d: %s", e.File, e.Line, e.Msg)
+	}
+	return fmt.Sprintf("%s:%d: %s: %s", e.File, e.Line, e.Field, e.Msg)
+}
+
+func parseJSON(name string, data []byte) (*jsonNode, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	node, err := parseJSONValue(dec, data)
+	if err == nil {
+		if _, extra := dec.Token(); extra != io.EOF {
+			err = fmt.Errorf("unexpected data after top-level value")
+		}
+	}
+	if err != nil {
+		offset := dec.InputOffset()
+		var syntaxErr *json.SyntaxError
+		if errors.As(err, &syntaxErr) {
+			offset = syntaxErr.Offset
+		}
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, &jsonError{File: name, Line: lineAt(data, offset), Msg: err.Error()}
+	}
+
+	return node, nil
+}
+
+func parseJSONValue(dec *json.Decoder, data []byte) (*jsonNode, error) {
+	offset := skipJSONSeparators(data, dec.InputOffset())
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	node := &jsonNode{Line: lineAt(data, offset)}
+	switch v := tok.(type) {
+	case nil:
+		node.Kind = jsonNull
+	case bool:
+		node.Kind = jsonBool
+		node.Bool = v
+	case json.Number:
+		node.Kind = jsonNumber
+		node.Number, err = v.Float64()
+		if err != nil {
+			return nil, err
+		}
+	case string:
+		node.Kind = jsonString
+		node.String = v
+	case json.Delim:
+		switch v {
+		case '[':
+			node.Kind = jsonArray
+			for dec.More() {
+				item, err := parseJSONValue(dec, data)
+				if err != nil {
+					return nil, err
+				}
+				node.Items = append(node.Items, item)
+			}
+		case '{':
+			node.Kind = jsonObject
+			node.Fields = map[string]*jsonNode{}
+			for dec.More() {
+				keyTok, err := dec.Token()
+				if err != nil {
+					return nil, err
+				}
+				key := keyTok.(string)
+				if _, ok := node.Fields[key]; ok {
+					return nil, fmt.Errorf("duplicate key %q", key)
+				}
+				value, err := parseJSONValue(dec, data)
+				if err != nil {
+					return nil, err
+				}
+				node.Keys = append(node.Keys, key)
+				node.Fields[key] = value
+			}
+		}
+		if _, err := dec.Token(); err != nil {
+			return nil, err
+		}
+	}
+
+	return node, nil
+}
+
+func skipJSONSeparators(data []byte, offset int64) int64 {
+	for offset < int64(len(data)) {
+		switch data[offset] {
+		case ' ', '\t', '\r', '\n', ',', ':':
+			offset++
+		default:
+			return offset
+		}
+	}
+	return offset
+}
+
+func lineAt(data []byte, offset int64) int {
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+	return bytes.Count(data[:offset], []byte{'\n'}) + 1
+}
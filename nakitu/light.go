@@ -0,0 +1,332 @@
+package nakitu
+
+import (
+	"math"
+	"sort"
+)
+
+// Light is an emitting surface that the renderer can aim rays at.
+//
+// SampleDirection returns a direction from origin toward a random point on
+// the light. PDFValue returns the density, per unit solid angle, with which
+// SampleDirection picks dir, or 0 if a ray along dir misses the light.
+type Light interface {
+	Hittable
+	SampleDirection(origin Point3, rnd *Rand) Vec3
+	PDFValue(origin Point3, dir Vec3, rnd *Rand) float64
+}
+
+// LightList picks one of its lights with equal probability.
+type LightList struct {
+	Lights []Light
+}
+
+// CollectLights finds the rectangles, spheres, triangles and mesh faces with a
+// DiffuseLight material in world, looking inside lists, BVHs, boxes,
+// transforms and instances. Moving spheres and anything inside a constant
+// medium are not collected; they are still lit by rays that happen to hit
+// them.
+func CollectLights(world Hittable) *LightList {
+	lights := &LightList{}
+	lights.collect(world, lightContext{})
+	return lights
+}
+
+// lightContext is what the objects above a light do to it: the transform
+// into world space, if there is one, and the material an Instance gives it.
+type lightContext struct {
+	transformed       bool
+	toWorld, toObject Matrix4
+	mat               Material
+}
+
+// then adds a transform below the ones already in c.
+func (c lightContext) then(toWorld, toObject Matrix4) lightContext {
+	if !c.transformed {
+		c.transformed = true
+		c.toWorld, c.toObject = Identity(), Identity()
+	}
+	c.toWorld = c.toWorld.Mul(toWorld)
+	c.toObject = toObject.Mul(c.toObject)
+	return c
+}
+
+func (c lightContext) material(mat Material) Material {
+	if c.mat != nil {
+		return c.mat
+	}
+	return mat
+}
+
+func (l *LightList) collect(h Hittable, c lightContext) {
+	var mat Material
+	switch h := h.(type) {
+	case *HittableList:
+		for _, obj := range h.Objects {
+			l.collect(obj, c)
+		}
+		return
+	case *BVHNode:
+		for _, obj := range h.objects {
+			l.collect(obj, c)
+		}
+		return
+	case *Box:
+		l.collect(h.Sides, c)
+		return
+	case *Translate:
+		l.collect(h.Obj, c.then(NewTranslation(h.Offset), NewTranslation(h.Offset.Mulf(-1))))
+		return
+	case *RotateY:
+		toWorld := Identity()
+		toWorld[0][0], toWorld[0][2] = h.CosTheta, h.SinTheta
+		toWorld[2][0], toWorld[2][2] = -h.SinTheta, h.CosTheta
+		l.collect(h.Obj, c.then(toWorld, toWorld.Transpose()))
+		return
+	case *Transform:
+		l.collect(h.Obj, c.then(h.Matrix, h.Inverse))
+		return
+	case *Instance:
+		// an outer instance's material replaces an inner one's
+		if c.mat == nil {
+			c.mat = h.Mat
+		}
+		l.collect(h.Obj, c.then(h.Matrix, h.Inverse))
+		return
+	case *TriangleMesh:
+		if light := newMeshLight(h, c); light != nil {
+			l.add(light, c)
+		}
+		return
+	case *XYRect:
+		mat = h.Mat
+	case *XZRect:
+		mat = h.Mat
+	case *YZRect:
+		mat = h.Mat
+	case *Sphere:
+		mat = h.Mat
+	case *Triangle:
+		mat = h.Mat
+	default:
+		return
+	}
+
+	if _, ok := c.material(mat).(*DiffuseLight); ok {
+		l.add(h.(Light), c)
+	}
+}
+
+func (l *LightList) add(light Light, c lightContext) {
+	if c.transformed {
+		light = &transformedLight{
+			Transform: Transform{Obj: light, Matrix: c.toWorld, Inverse: c.toObject, normal: c.toObject.Transpose()},
+			light:     light,
+		}
+	}
+	l.Lights = append(l.Lights, light)
+}
+
+func (l *LightList) SampleDirection(origin Point3, rnd *Rand) Vec3 {
+	return l.Lights[rnd.Intn(len(l.Lights))].SampleDirection(origin, rnd)
+}
+
+func (l *LightList) PDFValue(origin Point3, dir Vec3, rnd *Rand) float64 {
+	if len(l.Lights) == 0 {
+		return 0
+	}
+
+	sum := 0.0
+	for _, light := range l.Lights {
+		sum += light.PDFValue(origin, dir, rnd)
+	}
+	return sum / float64(len(l.Lights))
+}
+
+// powerHeuristic is the weight of a sample taken with density pdf when the
+// same direction could also have been taken with density other.
+func powerHeuristic(pdf, other float64) float64 {
+	if pdf <= 0 {
+		return 0
+	}
+	return pdf * pdf / (pdf*pdf + other*other)
+}
+
+// areaLightPDF converts the density of a point picked uniformly on a flat
+// light of the given area and normal into a density per solid angle.
+func areaLightPDF(light Hittable, origin Point3, dir, normal Vec3, area float64, rnd *Rand) float64 {
+	var rec HitRecord
+	if !light.Hit(NewRay(origin, dir, 0), 0.001, math.Inf(1), &rec, rnd) {
+		return 0
+	}
+	return solidAnglePDF(rec.T, dir, normal, area)
+}
+
+// solidAnglePDF is the density per solid angle of a point picked uniformly on
+// a surface of the given area, seen along dir at ray parameter t.
+func solidAnglePDF(t float64, dir, normal Vec3, area float64) float64 {
+	distSquared := t * t * dir.LenSquared()
+	cosine := math.Abs(dir.Dot(normal)) / dir.Len()
+	if cosine == 0 {
+		return 0
+	}
+	return distSquared / (cosine * area)
+}
+
+func (s *XYRect) SampleDirection(origin Point3, rnd *Rand) Vec3 {
+	p := NewVec3(rnd.Random(s.X0, s.X1), rnd.Random(s.Y0, s.Y1), s.K)
+	return p.Sub(origin)
+}
+
+func (s *XYRect) PDFValue(origin Point3, dir Vec3, rnd *Rand) float64 {
+	area := (s.X1 - s.X0) * (s.Y1 - s.Y0)
+	return areaLightPDF(s, origin, dir, NewVec3(0, 0, 1), area, rnd)
+}
+
+func (s *XZRect) SampleDirection(origin Point3, rnd *Rand) Vec3 {
+	p := NewVec3(rnd.Random(s.X0, s.X1), s.K, rnd.Random(s.Z0, s.Z1))
+	return p.Sub(origin)
+}
+
+func (s *XZRect) PDFValue(origin Point3, dir Vec3, rnd *Rand) float64 {
+	area := (s.X1 - s.X0) * (s.Z1 - s.Z0)
+	return areaLightPDF(s, origin, dir, NewVec3(0, 1, 0), area, rnd)
+}
+
+func (s *YZRect) SampleDirection(origin Point3, rnd *Rand) Vec3 {
+	p := NewVec3(s.K, rnd.Random(s.Y0, s.Y1), rnd.Random(s.Z0, s.Z1))
+	return p.Sub(origin)
+}
+
+func (s *YZRect) PDFValue(origin Point3, dir Vec3, rnd *Rand) float64 {
+	area := (s.Y1 - s.Y0) * (s.Z1 - s.Z0)
+	return areaLightPDF(s, origin, dir, NewVec3(1, 0, 0), area, rnd)
+}
+
+// SampleDirection picks a direction uniformly inside the cone the sphere
+// covers as seen from origin. From inside the sphere, where there is no such
+// cone, it picks a point uniformly on the surface instead.
+func (s *Sphere) SampleDirection(origin Point3, rnd *Rand) Vec3 {
+	toCenter := s.Center.Sub(origin)
+	distSquared := toCenter.LenSquared()
+	if distSquared <= s.Radius*s.Radius {
+		return s.Center.Add(RandomUnitVector(rnd).Mulf(s.Radius)).Sub(origin)
+	}
+
+	cosMax := math.Sqrt(1 - s.Radius*s.Radius/distSquared)
+	z := 1 + rnd.Float64()*(cosMax-1)
+	phi := 2 * math.Pi * rnd.Float64()
+	sinTheta := math.Sqrt(1 - z*z)
+	return NewONB(toCenter).Local(NewVec3(math.Cos(phi)*sinTheta, math.Sin(phi)*sinTheta, z))
+}
+
+func (s *Sphere) PDFValue(origin Point3, dir Vec3, rnd *Rand) float64 {
+	toCenter := s.Center.Sub(origin)
+	distSquared := toCenter.LenSquared()
+
+	var rec HitRecord
+	if !s.Hit(NewRay(origin, dir, 0), 0.001, math.Inf(1), &rec, rnd) {
+		return 0
+	}
+	if distSquared <= s.Radius*s.Radius {
+		normal := rec.Point.Sub(s.Center).Divf(s.Radius)
+		cosine := math.Abs(dir.Dot(normal)) / dir.Len()
+		if cosine == 0 {
+			return 0
+		}
+		return rec.T * rec.T * dir.LenSquared() / (cosine * 4 * math.Pi * s.Radius * s.Radius)
+	}
+
+	cosMax := math.Sqrt(1 - s.Radius*s.Radius/distSquared)
+	return 1 / (2 * math.Pi * (1 - cosMax))
+}
+
+func (tr *Triangle) SampleDirection(origin Point3, rnd *Rand) Vec3 {
+	return sampleTriangle(tr.V0, tr.V1, tr.V2, rnd).Sub(origin)
+}
+
+func (tr *Triangle) PDFValue(origin Point3, dir Vec3, rnd *Rand) float64 {
+	area := 0.5 * tr.V2.Sub(tr.V0).Cross(tr.V1.Sub(tr.V0)).Len()
+	normal := triangleNormal(tr.V0, tr.V1, tr.V2)
+	return areaLightPDF(tr, origin, dir, normal, area, rnd)
+}
+
+// sampleTriangle picks a point uniformly on a triangle.
+func sampleTriangle(v0, v1, v2 Point3, rnd *Rand) Point3 {
+	// uniform barycentric coordinates
+	su := math.Sqrt(rnd.Float64())
+	b1 := 1 - su
+	b2 := rnd.Float64() * su
+	return v0.Add(v1.Sub(v0).Mulf(b1)).Add(v2.Sub(v0).Mulf(b2))
+}
+
+// meshLight is the emissive faces of a TriangleMesh, picked in proportion to
+// their area.
+type meshLight struct {
+	*TriangleMesh
+	faces    []int
+	cdf      []float64
+	emissive []bool
+}
+
+// newMeshLight returns nil if no face of m emits.
+func newMeshLight(m *TriangleMesh, c lightContext) *meshLight {
+	l := &meshLight{TriangleMesh: m, emissive: make([]bool, m.NumFaces())}
+	area := 0.0
+	for face := range l.emissive {
+		if _, ok := c.material(m.material(face)).(*DiffuseLight); !ok {
+			continue
+		}
+		v0, v1, v2 := m.vertices(face)
+		area += 0.5 * v2.Sub(v0).Cross(v1.Sub(v0)).Len()
+		l.faces = append(l.faces, face)
+		l.cdf = append(l.cdf, area)
+		l.emissive[face] = true
+	}
+	if area == 0 {
+		return nil
+	}
+	return l
+}
+
+func (l *meshLight) SampleDirection(origin Point3, rnd *Rand) Vec3 {
+	area := l.cdf[len(l.cdf)-1]
+	i := sort.SearchFloat64s(l.cdf, rnd.Float64()*area)
+	if i == len(l.faces) {
+		i--
+	}
+	v0, v1, v2 := l.vertices(l.faces[i])
+	return sampleTriangle(v0, v1, v2, rnd).Sub(origin)
+}
+
+func (l *meshLight) PDFValue(origin Point3, dir Vec3, rnd *Rand) float64 {
+	var rec HitRecord
+	if !l.Hit(NewRay(origin, dir, 0), 0.001, math.Inf(1), &rec, rnd) || !l.emissive[rec.Face] {
+		return 0
+	}
+	v0, v1, v2 := l.vertices(rec.Face)
+	return solidAnglePDF(rec.T, dir, triangleNormal(v0, v1, v2), l.cdf[len(l.cdf)-1])
+}
+
+// transformedLight is a light placed by an affine transform. The transform
+// takes rays to rays, so directions are sampled in object space and carried
+// out to world space, and their density changes by the Jacobian of the map
+// between the two spaces' unit directions.
+type transformedLight struct {
+	Transform
+	light Light
+}
+
+func (t *transformedLight) SampleDirection(origin Point3, rnd *Rand) Vec3 {
+	return t.Matrix.MulVector(t.light.SampleDirection(t.Inverse.MulPoint(origin), rnd))
+}
+
+func (t *transformedLight) PDFValue(origin Point3, dir Vec3, rnd *Rand) float64 {
+	local := t.Inverse.MulVector(dir.Unit())
+	pdf := t.light.PDFValue(t.Inverse.MulPoint(origin), local, rnd)
+	if pdf == 0 {
+		return 0
+	}
+	n := local.Len()
+	return pdf * math.Abs(t.Inverse.Det3()) / (n * n * n)
+}
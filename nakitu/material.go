@@ -1,21 +1,57 @@
 package nakitu
 
-import (
-	"math"
-	"math/rand"
-)
-
+import "math"
+
+// Material describes how light leaves a surface.
+//
+// Sample picks an outgoing direction for light arriving along rIn. Eval
+// returns the BSDF for scattering into dir times the cosine with the normal,
+// and PDF the density with which Sample would have picked dir. Specular
+// materials choose a single direction, so they return zero from Eval and PDF
+// and mark their samples as specular instead.
 type Material interface {
-	Scatter(rIn *Ray, rec *HitRecord, attenuation *Color, scattered *Ray) bool
+	Sample(rIn *Ray, rec *HitRecord, s *BSDFSample, rnd *Rand) bool
+	Eval(rIn *Ray, rec *HitRecord, dir Vec3) Color
+	PDF(rIn *Ray, rec *HitRecord, dir Vec3) float64
 	Emitted(u, v float64, p Point3) Color
 }
 
+// BSDFSample is a direction chosen by Material.Sample. F is what Eval returns
+// for Dir and PDF its density. For specular samples PDF is unused and F is
+// the weight of the path through Dir.
+type BSDFSample struct {
+	Dir      Vec3
+	F        Color
+	PDF      float64
+	Specular bool
+}
+
+// Weight is the factor the light arriving along Dir is scaled by.
+func (s *BSDFSample) Weight() Color {
+	if s.Specular {
+		return s.F
+	}
+	return s.F.Divf(s.PDF)
+}
+
 type DefaultEmitter struct{}
 
 func (d *DefaultEmitter) Emitted(u, v float64, p Point3) Color {
 	return NewVec3(0, 0, 0)
 }
 
+// specular is embedded by materials that scatter into single directions, or
+// not at all.
+type specular struct{}
+
+func (specular) Eval(rIn *Ray, rec *HitRecord, dir Vec3) Color {
+	return Zero()
+}
+
+func (specular) PDF(rIn *Ray, rec *HitRecord, dir Vec3) float64 {
+	return 0
+}
+
 type Lambertian struct {
 	Albedo Texture
 	DefaultEmitter
@@ -25,46 +61,71 @@ func NewLambertian(a Texture) *Lambertian {
 	return &Lambertian{Albedo: a}
 }
 
-func (l *Lambertian) Scatter(rIn *Ray, rec *HitRecord, attenuation *Color, scattered *Ray) bool {
-	scatterDir := rec.Normal.Add(RandomUnitVector())
-
-	if scatterDir.NearZero() {
-		scatterDir = rec.Normal
+// Sample picks directions with a density proportional to the cosine with the
+// normal, which cancels the cosine in the BSDF.
+func (l *Lambertian) Sample(rIn *Ray, rec *HitRecord, s *BSDFSample, rnd *Rand) bool {
+	s.Dir = NewONB(rec.Normal).Local(RandomCosineDirection(rnd))
+	cosine := s.Dir.Dot(rec.Normal)
+	if cosine <= 0 {
+		return false
 	}
 
-	*scattered = *NewRay(rec.Point, scatterDir, rIn.Time)
-	*attenuation = l.Albedo.Value(rec.U, rec.V, rec.Point)
+	s.F = textureValue(l.Albedo, rec).Mulf(cosine / math.Pi)
+	s.PDF = cosine / math.Pi
+	s.Specular = false
 	return true
 }
 
+func (l *Lambertian) Eval(rIn *Ray, rec *HitRecord, dir Vec3) Color {
+	cosine := dir.Unit().Dot(rec.Normal)
+	if cosine <= 0 {
+		return Zero()
+	}
+	return textureValue(l.Albedo, rec).Mulf(cosine / math.Pi)
+}
+
+func (l *Lambertian) PDF(rIn *Ray, rec *HitRecord, dir Vec3) float64 {
+	cosine := dir.Unit().Dot(rec.Normal)
+	if cosine <= 0 {
+		return 0
+	}
+	return cosine / math.Pi
+}
+
 type Metal struct {
 	Albedo Color
 	Fuzz   float64
 	DefaultEmitter
+	specular
 }
 
 func NewMetal(a Color, f float64) *Metal {
 	return &Metal{Albedo: a, Fuzz: f}
 }
 
-func (m *Metal) Scatter(rIn *Ray, rec *HitRecord, attenuation *Color, scattered *Ray) bool {
+// Sample reflects about the normal and jitters the result by Fuzz. The
+// jitter has no closed-form density, so fuzzy metal is still treated as
+// specular.
+func (m *Metal) Sample(rIn *Ray, rec *HitRecord, s *BSDFSample, rnd *Rand) bool {
 	reflected := rIn.Dir.Unit().Reflect(rec.Normal)
-	*scattered = *NewRay(rec.Point, reflected.Add(RandomInUnitSphere().Mulf(m.Fuzz)), rIn.Time)
-	*attenuation = m.Albedo
-	return scattered.Dir.Dot(rec.Normal) > 0
+	s.Dir = reflected.Add(RandomInUnitSphere(rnd).Mulf(m.Fuzz))
+	s.F = m.Albedo
+	s.PDF = 0
+	s.Specular = true
+	return s.Dir.Dot(rec.Normal) > 0
 }
 
 type Dielectric struct {
 	Ir float64
 	DefaultEmitter
+	specular
 }
 
 func NewDielectric(indexOfRefraction float64) *Dielectric {
 	return &Dielectric{Ir: indexOfRefraction}
 }
 
-func (d *Dielectric) Scatter(rIn *Ray, rec *HitRecord, attenuation *Color, scattered *Ray) bool {
-	*attenuation = NewVec3(1, 1, 1)
+func (d *Dielectric) Sample(rIn *Ray, rec *HitRecord, s *BSDFSample, rnd *Rand) bool {
 	var refractionRatio float64
 	if rec.frontFace {
 		refractionRatio = 1.0 / d.Ir
@@ -79,13 +140,16 @@ func (d *Dielectric) Scatter(rIn *Ray, rec *HitRecord, attenuation *Color, scatt
 	cannotRefract := refractionRatio*sinTheta > 1
 	var dir Vec3
 
-	if cannotRefract || reflectance(cosTheta, refractionRatio) > rand.Float64() {
+	if cannotRefract || reflectance(cosTheta, refractionRatio) > rnd.Float64() {
 		dir = unitDir.Reflect(rec.Normal)
 	} else {
 		dir = unitDir.Refract(rec.Normal, refractionRatio)
 	}
 
-	*scattered = *NewRay(rec.Point, dir, rIn.Time)
+	s.Dir = dir
+	s.F = NewVec3(1, 1, 1)
+	s.PDF = 0
+	s.Specular = true
 	return true
 }
 
@@ -97,6 +161,7 @@ func reflectance(cosine, refIdx float64) float64 {
 
 type DiffuseLight struct {
 	Emit Texture
+	specular
 }
 
 func NewDiffuseLight(t Texture) *DiffuseLight {
@@ -105,7 +170,7 @@ func NewDiffuseLight(t Texture) *DiffuseLight {
 	}
 }
 
-func (d *DiffuseLight) Scatter(rIn *Ray, rec *HitRecord, attenuation *Color, scattered *Ray) bool {
+func (d *DiffuseLight) Sample(rIn *Ray, rec *HitRecord, s *BSDFSample, rnd *Rand) bool {
 	return false
 }
 
@@ -124,8 +189,20 @@ func NewIsotropic(tex Texture) *Isotropic {
 	}
 }
 
-func (i *Isotropic) Scatter(rIn *Ray, rec *HitRecord, attenuation *Color, scattered *Ray) bool {
-	*scattered = *NewRay(rec.Point, RandomInUnitSphere(), rIn.Time)
-	*attenuation = i.Albedo.Value(rec.U, rec.V, rec.Point)
+// Sample scatters uniformly over the sphere. Isotropic is a phase function,
+// so there is no cosine term.
+func (i *Isotropic) Sample(rIn *Ray, rec *HitRecord, s *BSDFSample, rnd *Rand) bool {
+	s.Dir = RandomUnitVector(rnd)
+	s.F = textureValue(i.Albedo, rec).Divf(4 * math.Pi)
+	s.PDF = 1 / (4 * math.Pi)
+	s.Specular = false
 	return true
 }
+
+func (i *Isotropic) Eval(rIn *Ray, rec *HitRecord, dir Vec3) Color {
+	return textureValue(i.Albedo, rec).Divf(4 * math.Pi)
+}
+
+func (i *Isotropic) PDF(rIn *Ray, rec *HitRecord, dir Vec3) float64 {
+	return 1 / (4 * math.Pi)
+}
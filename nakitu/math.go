@@ -20,3 +20,183 @@ func Random(min, max float64) float64 {
 func RandomInt(min, max int) int {
 	return min + rand.Intn(max-min)
 }
+
+// Matrix4 is a 4x4 matrix, m[row][column], applied to column vectors. Points
+// have an implicit w of 1 and vectors one of 0.
+type Matrix4 [4][4]float64
+
+func Identity() Matrix4 {
+	return Matrix4{
+		{1, 0, 0, 0},
+		{0, 1, 0, 0},
+		{0, 0, 1, 0},
+		{0, 0, 0, 1},
+	}
+}
+
+func NewTranslation(offset Vec3) Matrix4 {
+	m := Identity()
+	m[0][3] = offset[0]
+	m[1][3] = offset[1]
+	m[2][3] = offset[2]
+	return m
+}
+
+func NewScale(s Vec3) Matrix4 {
+	m := Identity()
+	m[0][0] = s[0]
+	m[1][1] = s[1]
+	m[2][2] = s[2]
+	return m
+}
+
+// NewRotation rotates counter-clockwise by angle degrees about axis, looking
+// down the axis towards the origin.
+func NewRotation(axis Vec3, angle float64) Matrix4 {
+	a := axis.Unit()
+	x, y, z := a[0], a[1], a[2]
+	s, c := math.Sin(Rad(angle)), math.Cos(Rad(angle))
+	t := 1 - c
+
+	return Matrix4{
+		{t*x*x + c, t*x*y - s*z, t*x*z + s*y, 0},
+		{t*x*y + s*z, t*y*y + c, t*y*z - s*x, 0},
+		{t*x*z - s*y, t*y*z + s*x, t*z*z + c, 0},
+		{0, 0, 0, 1},
+	}
+}
+
+// NewLookAt places an object at from, turned so that its -Z axis points at to
+// and its +Y axis is as close to up as possible, like a camera.
+func NewLookAt(from, to Point3, up Vec3) Matrix4 {
+	// note that a.Cross(b) computes b x a
+	w := from.Sub(to).Unit()
+	u := w.Cross(up).Unit()
+	v := u.Cross(w)
+
+	return Matrix4{
+		{u[0], v[0], w[0], from[0]},
+		{u[1], v[1], w[1], from[1]},
+		{u[2], v[2], w[2], from[2]},
+		{0, 0, 0, 1},
+	}
+}
+
+// Compose returns the transform that applies ms in order, so that
+// Compose(NewScale(s), NewTranslation(t)) scales first and then translates.
+func Compose(ms ...Matrix4) Matrix4 {
+	m := Identity()
+	for _, next := range ms {
+		m = next.Mul(m)
+	}
+	return m
+}
+
+func (m Matrix4) Mul(o Matrix4) Matrix4 {
+	var r Matrix4
+	for i := 0; i < 4; i++ {
+		for j := 0; j < 4; j++ {
+			for k := 0; k < 4; k++ {
+				r[i][j] += m[i][k] * o[k][j]
+			}
+		}
+	}
+	return r
+}
+
+func (m Matrix4) MulPoint(p Point3) Point3 {
+	q := m.MulVector(p).Add(NewVec3(m[0][3], m[1][3], m[2][3]))
+	w := m[3][0]*p[0] + m[3][1]*p[1] + m[3][2]*p[2] + m[3][3]
+	if w != 1 && w != 0 {
+		q = q.Divf(w)
+	}
+	return q
+}
+
+func (m Matrix4) MulVector(v Vec3) Vec3 {
+	return NewVec3(
+		m[0][0]*v[0]+m[0][1]*v[1]+m[0][2]*v[2],
+		m[1][0]*v[0]+m[1][1]*v[1]+m[1][2]*v[2],
+		m[2][0]*v[0]+m[2][1]*v[1]+m[2][2]*v[2],
+	)
+}
+
+func (m Matrix4) Transpose() Matrix4 {
+	var t Matrix4
+	for i := 0; i < 4; i++ {
+		for j := 0; j < 4; j++ {
+			t[i][j] = m[j][i]
+		}
+	}
+	return t
+}
+
+// Det3 is the determinant of the upper-left 3x3 part; it is negative for
+// transforms that mirror.
+func (m Matrix4) Det3() float64 {
+	return m[0][0]*(m[1][1]*m[2][2]-m[1][2]*m[2][1]) -
+		m[0][1]*(m[1][0]*m[2][2]-m[1][2]*m[2][0]) +
+		m[0][2]*(m[1][0]*m[2][1]-m[1][1]*m[2][0])
+}
+
+// Inverse inverts m by Gauss-Jordan elimination with partial pivoting. It
+// returns false if m is singular.
+func (m Matrix4) Inverse() (Matrix4, bool) {
+	a := m
+	inv := Identity()
+	for col := 0; col < 4; col++ {
+		pivot := col
+		for row := col + 1; row < 4; row++ {
+			if math.Abs(a[row][col]) > math.Abs(a[pivot][col]) {
+				pivot = row
+			}
+		}
+		if math.Abs(a[pivot][col]) < 1e-12 {
+			return Matrix4{}, false
+		}
+		a[col], a[pivot] = a[pivot], a[col]
+		inv[col], inv[pivot] = inv[pivot], inv[col]
+
+		f := 1 / a[col][col]
+		for j := 0; j < 4; j++ {
+			a[col][j] *= f
+			inv[col][j] *= f
+		}
+		for row := 0; row < 4; row++ {
+			if row == col || a[row][col] == 0 {
+				continue
+			}
+			f := a[row][col]
+			for j := 0; j < 4; j++ {
+				a[row][j] -= f * a[col][j]
+				inv[row][j] -= f * inv[col][j]
+			}
+		}
+	}
+	return inv, true
+}
+
+// TransformBox is the axis-aligned box around box transformed by m.
+func (m Matrix4) TransformBox(box *AABB) *AABB {
+	posInf := math.Inf(1)
+	negInf := math.Inf(-1)
+	min := NewVec3(posInf, posInf, posInf)
+	max := NewVec3(negInf, negInf, negInf)
+
+	for i := 0; i < 8; i++ {
+		corner := box.Min
+		for axis := 0; axis < 3; axis++ {
+			if i&(1<<axis) != 0 {
+				corner[axis] = box.Max[axis]
+			}
+		}
+
+		p := m.MulPoint(corner)
+		for axis := 0; axis < 3; axis++ {
+			min[axis] = math.Min(min[axis], p[axis])
+			max[axis] = math.Max(max[axis], p[axis])
+		}
+	}
+
+	return NewAABB(min, max)
+}
@@ -0,0 +1,207 @@
+package nakitu
+
+import "fmt"
+
+// meshLeafSize is the most triangles kept in one leaf of a mesh BVH.
+const meshLeafSize = 4
+
+// TriangleMesh is an indexed triangle mesh. Vertex attributes are shared
+// between faces and the mesh keeps its own BVH over the faces, so a large
+// model costs a few words per triangle instead of a Hittable each.
+//
+// Normals, UVs and Colors are optional and, when present, are indexed like
+// Positions. Colors are read by VertexColorTexture.
+// MaterialIDs, when present, picks one of Materials per face; otherwise every
+// face uses Materials[0].
+type TriangleMesh struct {
+	Positions   []Point3
+	Normals     []Vec3
+	UVs         []Vec2
+	Colors      []Color
+	Indices     []uint32
+	MaterialIDs []uint16
+	Materials   []Material
+
+	faces []uint32
+	nodes []bvhNode
+	stats BVHStats
+}
+
+func NewTriangleMesh(positions []Point3, indices []uint32, mat Material) (*TriangleMesh, error) {
+	m := &TriangleMesh{
+		Positions: positions,
+		Indices:   indices,
+		Materials: []Material{mat},
+	}
+	if err := m.Build(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (m *TriangleMesh) NumFaces() int {
+	return len(m.Indices) / 3
+}
+
+// Build checks the buffers and builds the BVH. It has to be called again
+// after the buffers are changed.
+func (m *TriangleMesh) Build() error {
+	if len(m.Indices)%3 != 0 {
+		return fmt.Errorf("mesh has %d indices, not a multiple of 3", len(m.Indices))
+	}
+	if m.NumFaces() == 0 {
+		return fmt.Errorf("mesh has no faces")
+	}
+	if m.Normals != nil && len(m.Normals) != len(m.Positions) {
+		return fmt.Errorf("mesh has %d normals for %d positions", len(m.Normals), len(m.Positions))
+	}
+	if m.UVs != nil && len(m.UVs) != len(m.Positions) {
+		return fmt.Errorf("mesh has %d uvs for %d positions", len(m.UVs), len(m.Positions))
+	}
+	if m.Colors != nil && len(m.Colors) != len(m.Positions) {
+		return fmt.Errorf("mesh has %d colors for %d positions", len(m.Colors), len(m.Positions))
+	}
+	for i, index := range m.Indices {
+		if int(index) >= len(m.Positions) {
+			return fmt.Errorf("face %d: vertex index %d out of range (%d positions)", i/3, index, len(m.Positions))
+		}
+	}
+	if len(m.Materials) == 0 {
+		return fmt.Errorf("mesh has no materials")
+	}
+	if m.MaterialIDs != nil {
+		if len(m.MaterialIDs) != m.NumFaces() {
+			return fmt.Errorf("mesh has %d material ids for %d faces", len(m.MaterialIDs), m.NumFaces())
+		}
+		for face, id := range m.MaterialIDs {
+			if int(id) >= len(m.Materials) {
+				return fmt.Errorf("face %d: material id %d out of range (%d materials)", face, id, len(m.Materials))
+			}
+		}
+	}
+
+	n := m.NumFaces()
+	boxes := make([]AABB, n)
+	for face := 0; face < n; face++ {
+		boxes[face] = *triangleBox(m.vertices(face))
+	}
+
+	build := buildBVH(boxes, meshLeafSize)
+	m.stats = build.stats
+	m.faces = make([]uint32, n)
+	for i, face := range build.order {
+		m.faces[i] = uint32(face)
+	}
+	m.nodes = flattenBVH(build.root, build.stats.Nodes)
+
+	return nil
+}
+
+// Stats describes the BVH over the faces.
+func (m *TriangleMesh) Stats() BVHStats {
+	return m.stats
+}
+
+func (m *TriangleMesh) vertices(face int) (Point3, Point3, Point3) {
+	i := m.Indices[3*face : 3*face+3]
+	return m.Positions[i[0]], m.Positions[i[1]], m.Positions[i[2]]
+}
+
+func (m *TriangleMesh) Hit(r *Ray, tMin, tMax float64, rec *HitRecord, rnd *Rand) bool {
+	// a path from the root can push at most one node per level
+	var buf [64]int32
+	stack := buf[:]
+	if m.stats.Depth > len(buf) {
+		stack = make([]int32, m.stats.Depth)
+	}
+	top := 0
+	node := int32(0)
+
+	hitFace := -1
+	var hitB0, hitB1, hitB2 float64
+	invDir := NewVec3(1/r.Dir[0], 1/r.Dir[1], 1/r.Dir[2])
+	for {
+		n := &m.nodes[node]
+		if n.box.hitInv(r, invDir, tMin, tMax) {
+			if n.leaf() {
+				for _, face := range m.faces[n.first : n.first+n.count] {
+					v0, v1, v2 := m.vertices(int(face))
+					if t, b0, b1, b2, ok := intersectTriangle(r, v0, v1, v2, tMin, tMax); ok {
+						tMax = t
+						hitFace = int(face)
+						hitB0, hitB1, hitB2 = b0, b1, b2
+					}
+				}
+			} else if r.Dir[n.axis] < 0 {
+				// visit the nearer child first so that later boxes are culled
+				// by the closer hit
+				stack[top] = node + 1
+				top++
+				node = n.right
+				continue
+			} else {
+				stack[top] = n.right
+				top++
+				node++
+				continue
+			}
+		}
+
+		if top == 0 {
+			break
+		}
+		top--
+		node = stack[top]
+	}
+
+	if hitFace < 0 {
+		return false
+	}
+
+	m.setHitRecord(r, tMax, hitFace, hitB0, hitB1, hitB2, rec)
+	return true
+}
+
+func (m *TriangleMesh) setHitRecord(r *Ray, t float64, face int, b0, b1, b2 float64, rec *HitRecord) {
+	i := m.Indices[3*face : 3*face+3]
+
+	rec.T = t
+	rec.Point = r.At(t)
+
+	if m.UVs != nil {
+		uv0, uv1, uv2 := m.UVs[i[0]], m.UVs[i[1]], m.UVs[i[2]]
+		rec.U = b0*uv0[0] + b1*uv1[0] + b2*uv2[0]
+		rec.V = b0*uv0[1] + b1*uv1[1] + b2*uv2[1]
+	} else {
+		rec.U, rec.V = b1, b2
+	}
+
+	geometric := triangleNormal(m.Positions[i[0]], m.Positions[i[1]], m.Positions[i[2]])
+	if m.Normals != nil {
+		shading := m.Normals[i[0]].Mulf(b0).Add(m.Normals[i[1]].Mulf(b1)).Add(m.Normals[i[2]].Mulf(b2))
+		if shading.LenSquared() > 0 {
+			rec.SetShadingNormal(r, geometric, shading)
+		} else {
+			rec.SetFaceNormal(r, geometric)
+		}
+	} else {
+		rec.SetFaceNormal(r, geometric)
+	}
+
+	rec.Mat = m.material(face)
+	rec.Mesh = m
+	rec.Face = face
+	rec.Bary = NewVec3(b0, b1, b2)
+}
+
+func (m *TriangleMesh) material(face int) Material {
+	if m.MaterialIDs != nil {
+		return m.Materials[m.MaterialIDs[face]]
+	}
+	return m.Materials[0]
+}
+
+func (m *TriangleMesh) BoundingBox(time0, time1 float64, outputBox *AABB) bool {
+	*outputBox = m.nodes[0].box
+	return true
+}
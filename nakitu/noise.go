@@ -0,0 +1,288 @@
+package nakitu
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+const perlinPointCount = 256
+
+// PerlinInterpolation is how Perlin blends the gradients at the corners of a
+// lattice cell.
+type PerlinInterpolation int
+
+const (
+	// PerlinHermite eases the weights with a cubic, which hides the lattice.
+	PerlinHermite PerlinInterpolation = iota
+	// PerlinTrilinear uses the distances as they are, which is cheaper but
+	// leaves visible creases along the cell faces.
+	PerlinTrilinear
+)
+
+func ParsePerlinInterpolation(name string) (PerlinInterpolation, error) {
+	switch name {
+	case "hermite":
+		return PerlinHermite, nil
+	case "trilinear":
+		return PerlinTrilinear, nil
+	}
+	return 0, fmt.Errorf("unknown interpolation %q (want hermite or trilinear)", name)
+}
+
+// Perlin is a gradient noise generator. The same seed always gives the same
+// noise.
+type Perlin struct {
+	Interpolation PerlinInterpolation
+
+	ranvec [perlinPointCount]Vec3
+	permX  [perlinPointCount]int
+	permY  [perlinPointCount]int
+	permZ  [perlinPointCount]int
+}
+
+func NewPerlin(seed uint64) *Perlin {
+	rnd := NewRand(seed)
+	p := &Perlin{}
+	for i := range p.ranvec {
+		p.ranvec[i] = RandomUnitVector(rnd)
+	}
+	perlinPermute(&p.permX, rnd)
+	perlinPermute(&p.permY, rnd)
+	perlinPermute(&p.permZ, rnd)
+	return p
+}
+
+func perlinPermute(perm *[perlinPointCount]int, rnd *Rand) {
+	for i := range perm {
+		perm[i] = i
+	}
+	for i := len(perm) - 1; i > 0; i-- {
+		j := rnd.Intn(i + 1)
+		perm[i], perm[j] = perm[j], perm[i]
+	}
+}
+
+// Noise returns a value in about [-1, 1] that varies smoothly with p.
+func (pn *Perlin) Noise(p Point3) float64 {
+	fi, fj, fk := math.Floor(p[0]), math.Floor(p[1]), math.Floor(p[2])
+	u, v, w := p[0]-fi, p[1]-fj, p[2]-fk
+	i, j, k := int(fi), int(fj), int(fk)
+
+	var c [2][2][2]Vec3
+	for di := 0; di < 2; di++ {
+		for dj := 0; dj < 2; dj++ {
+			for dk := 0; dk < 2; dk++ {
+				c[di][dj][dk] = pn.ranvec[pn.permX[(i+di)&255]^
+					pn.permY[(j+dj)&255]^
+					pn.permZ[(k+dk)&255]]
+			}
+		}
+	}
+
+	uu, vv, ww := u, v, w
+	if pn.Interpolation == PerlinHermite {
+		uu = u * u * (3 - 2*u)
+		vv = v * v * (3 - 2*v)
+		ww = w * w * (3 - 2*w)
+	}
+
+	accum := 0.0
+	for di := 0; di < 2; di++ {
+		for dj := 0; dj < 2; dj++ {
+			for dk := 0; dk < 2; dk++ {
+				fdi, fdj, fdk := float64(di), float64(dj), float64(dk)
+				weight := NewVec3(u-fdi, v-fdj, w-fdk)
+				accum += (fdi*uu + (1-fdi)*(1-uu)) *
+					(fdj*vv + (1-fdj)*(1-vv)) *
+					(fdk*ww + (1-fdk)*(1-ww)) *
+					c[di][dj][dk].Dot(weight)
+			}
+		}
+	}
+	return accum
+}
+
+// Turbulence sums octaves layers of noise, each at twice the frequency and
+// half the weight of the one before, and returns the absolute value of the
+// sum.
+func (pn *Perlin) Turbulence(p Point3, octaves int) float64 {
+	accum := 0.0
+	weight := 1.0
+	for i := 0; i < octaves; i++ {
+		accum += weight * pn.Noise(p)
+		weight *= 0.5
+		p = p.Mulf(2)
+	}
+	return math.Abs(accum)
+}
+
+// Worley returns the distance from p to the nearest of a set of feature
+// points scattered one to each unit cell, in about [0, 1].
+func Worley(p Point3, seed uint64) float64 {
+	fi, fj, fk := math.Floor(p[0]), math.Floor(p[1]), math.Floor(p[2])
+
+	nearest := math.Inf(1)
+	for di := -1.0; di <= 1; di++ {
+		for dj := -1.0; dj <= 1; dj++ {
+			for dk := -1.0; dk <= 1; dk++ {
+				cell := NewVec3(fi+di, fj+dj, fk+dk)
+				h := mix64(seed ^ 0x9e3779b97f4a7c15)
+				h = mix64(h ^ uint64(int64(cell[0])))
+				h = mix64(h ^ uint64(int64(cell[1])))
+				h = mix64(h ^ uint64(int64(cell[2])))
+				rnd := NewRand(h)
+				feature := cell.Add(NewVec3(rnd.Float64(), rnd.Float64(), rnd.Float64()))
+				if d := feature.Sub(p).LenSquared(); d < nearest {
+					nearest = d
+				}
+			}
+		}
+	}
+	return math.Sqrt(nearest)
+}
+
+// ColorStop is a colour at a position of a ColorRamp.
+type ColorStop struct {
+	Pos   float64
+	Color Color
+}
+
+// ColorRamp maps a value to a colour by blending between stops. A nil ramp
+// goes from black at 0 to white at 1.
+type ColorRamp []ColorStop
+
+func NewColorRamp(stops ...ColorStop) ColorRamp {
+	r := append(ColorRamp(nil), stops...)
+	sort.SliceStable(r, func(i, j int) bool { return r[i].Pos < r[j].Pos })
+	return r
+}
+
+func (r ColorRamp) At(t float64) Color {
+	if len(r) == 0 {
+		t = Clamp(t, 0, 1)
+		return NewVec3(t, t, t)
+	}
+	if t <= r[0].Pos {
+		return r[0].Color
+	}
+	for i := 1; i < len(r); i++ {
+		if t < r[i].Pos {
+			a, b := r[i-1], r[i]
+			f := (t - a.Pos) / (b.Pos - a.Pos)
+			return a.Color.Mulf(1 - f).Add(b.Color.Mulf(f))
+		}
+	}
+	return r[len(r)-1].Color
+}
+
+// NoiseTexture shows plain Perlin noise, mapped from [-1, 1] onto the ramp.
+type NoiseTexture struct {
+	Noise *Perlin
+	Scale float64
+	Ramp  ColorRamp
+}
+
+func NewNoiseTexture(noise *Perlin, scale float64) *NoiseTexture {
+	return &NoiseTexture{
+		Noise: noise,
+		Scale: scale,
+	}
+}
+
+func (t *NoiseTexture) Value(u, v float64, p Point3) Color {
+	return t.Ramp.At(0.5 * (1 + t.Noise.Noise(p.Mulf(t.Scale))))
+}
+
+// TurbulenceTexture shows the turbulence of Perlin noise, which looks like
+// camouflage netting at a few octaves.
+type TurbulenceTexture struct {
+	Noise   *Perlin
+	Scale   float64
+	Octaves int
+	Ramp    ColorRamp
+}
+
+func NewTurbulenceTexture(noise *Perlin, scale float64) *TurbulenceTexture {
+	return &TurbulenceTexture{
+		Noise:   noise,
+		Scale:   scale,
+		Octaves: 7,
+	}
+}
+
+func (t *TurbulenceTexture) Value(u, v float64, p Point3) Color {
+	return t.Ramp.At(t.Noise.Turbulence(p.Mulf(t.Scale), t.Octaves))
+}
+
+// MarbleTexture is a sine wave along Z whose phase is shifted by turbulence,
+// giving veins that run across the Z axis. Scale is the frequency of the
+// veins and Turbulence how far they wander.
+type MarbleTexture struct {
+	Noise      *Perlin
+	Scale      float64
+	Turbulence float64
+	Octaves    int
+	Ramp       ColorRamp
+}
+
+func NewMarbleTexture(noise *Perlin, scale float64) *MarbleTexture {
+	return &MarbleTexture{
+		Noise:      noise,
+		Scale:      scale,
+		Turbulence: 10,
+		Octaves:    7,
+	}
+}
+
+func (t *MarbleTexture) Value(u, v float64, p Point3) Color {
+	phase := t.Scale*p.Z() + t.Turbulence*t.Noise.Turbulence(p, t.Octaves)
+	return t.Ramp.At(0.5 * (1 + math.Sin(phase)))
+}
+
+// WoodTexture is a set of rings around the Y axis, Scale rings per unit,
+// bent by turbulence. The ramp runs from the inside of a ring to its edge.
+type WoodTexture struct {
+	Noise      *Perlin
+	Scale      float64
+	Turbulence float64
+	Octaves    int
+	Ramp       ColorRamp
+}
+
+func NewWoodTexture(noise *Perlin, scale float64) *WoodTexture {
+	return &WoodTexture{
+		Noise:      noise,
+		Scale:      scale,
+		Turbulence: 1,
+		Octaves:    4,
+		Ramp: NewColorRamp(
+			ColorStop{0, NewVec3(0.55, 0.35, 0.17)},
+			ColorStop{1, NewVec3(0.30, 0.16, 0.07)},
+		),
+	}
+}
+
+func (t *WoodTexture) Value(u, v float64, p Point3) Color {
+	r := math.Hypot(p.X(), p.Z())*t.Scale + t.Turbulence*t.Noise.Turbulence(p, t.Octaves)
+	return t.Ramp.At(r - math.Floor(r))
+}
+
+// WorleyTexture shows cellular noise: the distance to the nearest of a set
+// of random points, Scale cells per unit, which looks like cells or stones.
+type WorleyTexture struct {
+	Seed  uint64
+	Scale float64
+	Ramp  ColorRamp
+}
+
+func NewWorleyTexture(seed uint64, scale float64) *WorleyTexture {
+	return &WorleyTexture{
+		Seed:  seed,
+		Scale: scale,
+	}
+}
+
+func (t *WorleyTexture) Value(u, v float64, p Point3) Color {
+	return t.Ramp.At(Worley(p.Mulf(t.Scale), t.Seed))
+}
@@ -0,0 +1,578 @@
+package nakitu
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// LoadOBJ reads a Wavefront OBJ file and the MTL libraries it refers to.
+// Every object or group becomes a TriangleMesh; a file with several of them is
+// returned as a BVH over the meshes. Polygons are triangulated.
+//
+// MTL materials map onto nakitu materials as follows: an emissive Ke makes a
+// DiffuseLight, a transparent d or illum 4, 6 or 7 a Dielectric with index Ni,
+// a specular Ks stronger than Kd or illum 3 a Metal whose fuzz follows Ns, and
+// anything else a Lambertian with Kd or map_Kd.
+func LoadOBJ(name string) (Hittable, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	l := &objLoader{
+		file:      name,
+		dir:       filepath.Dir(name),
+		materials: map[string]uint16{},
+		textures:  map[string]*ImageTexture{},
+		current:   -1,
+	}
+	if err := l.read(f); err != nil {
+		return nil, err
+	}
+
+	objects := NewHittableList()
+	for _, g := range l.groups {
+		if len(g.mesh.Indices) == 0 {
+			continue
+		}
+		if !g.hasNormals {
+			g.mesh.Normals = nil
+		}
+		if !g.hasUVs {
+			g.mesh.UVs = nil
+		}
+		g.mesh.Materials = l.mats
+		if err := g.mesh.Build(); err != nil {
+			return nil, fmt.Errorf("%s: %s: %v", name, g.name, err)
+		}
+		objects.Add(g.mesh)
+	}
+
+	switch len(objects.Objects) {
+	case 0:
+		return nil, fmt.Errorf("%s: no faces", name)
+	case 1:
+		return objects.Objects[0], nil
+	}
+	return NewBVHNode(objects, 0, 1), nil
+}
+
+type objLoader struct {
+	file string
+	dir  string
+	line int
+
+	positions []Point3
+	uvs       []Vec2
+	normals   []Vec3
+
+	groups []*objGroup
+	group  *objGroup
+
+	mats      []Material
+	materials map[string]uint16
+	textures  map[string]*ImageTexture
+	current   int
+}
+
+type objGroup struct {
+	name     string
+	mesh     *TriangleMesh
+	vertices map[[3]int]uint32
+	// hasNormals and hasUVs record whether any face used them; missing ones
+	// are left zero
+	hasNormals bool
+	hasUVs     bool
+}
+
+func (l *objLoader) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("%s:%d: %s", l.file, l.line, fmt.Sprintf(format, args...))
+}
+
+func (l *objLoader) read(f *os.File) error {
+	return scanStatements(f, &l.line, func(keyword string, args []string, rest string) error {
+		switch keyword {
+		case "v":
+			v, err := l.floats(args, 3)
+			if err != nil {
+				return err
+			}
+			l.positions = append(l.positions, NewVec3(v[0], v[1], v[2]))
+		case "vt":
+			v, err := l.floats(args, 1)
+			if err != nil {
+				return err
+			}
+			uv := NewVec2(v[0], 0)
+			if len(v) > 1 {
+				uv[1] = v[1]
+			}
+			l.uvs = append(l.uvs, uv)
+		case "vn":
+			v, err := l.floats(args, 3)
+			if err != nil {
+				return err
+			}
+			l.normals = append(l.normals, NewVec3(v[0], v[1], v[2]))
+		case "f":
+			return l.face(args)
+		case "o", "g":
+			l.group = nil
+			if rest != "" {
+				l.newGroup(rest)
+			}
+		case "usemtl":
+			id, ok := l.materials[rest]
+			if !ok {
+				// keep going with the default material like most viewers
+				l.current = -1
+				return nil
+			}
+			l.current = int(id)
+		case "mtllib":
+			for _, lib := range args {
+				if err := l.readMTL(filepath.Join(l.dir, lib)); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+func (l *objLoader) newGroup(name string) {
+	l.group = &objGroup{
+		name:     name,
+		mesh:     &TriangleMesh{},
+		vertices: map[[3]int]uint32{},
+	}
+	l.groups = append(l.groups, l.group)
+}
+
+// floats parses at least min numbers.
+func (l *objLoader) floats(args []string, min int) ([]float64, error) {
+	if len(args) < min {
+		return nil, l.errorf("expected %d numbers, got %d", min, len(args))
+	}
+
+	v := make([]float64, len(args))
+	for i, arg := range args {
+		x, err := strconv.ParseFloat(arg, 64)
+		if err != nil {
+			return nil, l.errorf("invalid number %q", arg)
+		}
+		v[i] = x
+	}
+	return v, nil
+}
+
+func (l *objLoader) face(args []string) error {
+	if len(args) < 3 {
+		return l.errorf("face has %d vertices, need at least 3", len(args))
+	}
+	if l.group == nil {
+		l.newGroup("default")
+	}
+	g := l.group
+
+	indices := make([]uint32, len(args))
+	points := make([]Point3, len(args))
+	for i, arg := range args {
+		key, err := l.vertexKey(arg)
+		if err != nil {
+			return err
+		}
+
+		index, ok := g.vertices[key]
+		if !ok {
+			index = uint32(len(g.mesh.Positions))
+			g.vertices[key] = index
+			g.mesh.Positions = append(g.mesh.Positions, l.positions[key[0]])
+
+			uv := Vec2{}
+			if key[1] >= 0 {
+				uv = l.uvs[key[1]]
+				g.hasUVs = true
+			}
+			g.mesh.UVs = append(g.mesh.UVs, uv)
+
+			n := Zero()
+			if key[2] >= 0 {
+				n = l.normals[key[2]]
+				g.hasNormals = true
+			}
+			g.mesh.Normals = append(g.mesh.Normals, n)
+		}
+		indices[i] = index
+		points[i] = l.positions[key[0]]
+	}
+
+	material := l.defaultMaterial()
+	for _, tri := range triangulate(points) {
+		g.mesh.Indices = append(g.mesh.Indices, indices[tri[0]], indices[tri[1]], indices[tri[2]])
+		g.mesh.MaterialIDs = append(g.mesh.MaterialIDs, material)
+	}
+	return nil
+}
+
+// vertexKey resolves a v, v/vt, v//vn or v/vt/vn reference to zero-based
+// indices, -1 for a missing one. Negative indices count back from the end.
+func (l *objLoader) vertexKey(arg string) ([3]int, error) {
+	key := [3]int{-1, -1, -1}
+	counts := [3]int{len(l.positions), len(l.uvs), len(l.normals)}
+
+	parts := strings.Split(arg, "/")
+	if len(parts) > 3 || parts[0] == "" {
+		return key, l.errorf("invalid face vertex %q", arg)
+	}
+	for i, part := range parts {
+		if part == "" {
+			continue
+		}
+		n, err := strconv.Atoi(part)
+		if err != nil {
+			return key, l.errorf("invalid face vertex %q", arg)
+		}
+		if n < 0 {
+			n += counts[i]
+		} else {
+			n--
+		}
+		if n < 0 || n >= counts[i] {
+			return key, l.errorf("face vertex %q refers to a missing element", arg)
+		}
+		key[i] = n
+	}
+	return key, nil
+}
+
+func (l *objLoader) defaultMaterial() uint16 {
+	if l.current >= 0 {
+		return uint16(l.current)
+	}
+
+	const name = ""
+	if id, ok := l.materials[name]; ok {
+		return id
+	}
+	id := uint16(len(l.mats))
+	l.materials[name] = id
+	l.mats = append(l.mats, NewLambertian(NewSolidColor(0.8, 0.8, 0.8)))
+	return id
+}
+
+// mtlMaterial collects the statements of one newmtl block.
+type mtlMaterial struct {
+	name  string
+	kd    Color
+	ks    Color
+	ke    Color
+	ns    float64
+	ni    float64
+	d     float64
+	illum int
+	mapKd Texture
+}
+
+func (m *mtlMaterial) material() Material {
+	switch {
+	case maxComponent(m.ke) > 0:
+		return NewDiffuseLight(NewSolidColor(m.ke[0], m.ke[1], m.ke[2]))
+	case m.d < 1 || m.illum == 4 || m.illum == 6 || m.illum == 7:
+		return NewDielectric(m.ni)
+	case maxComponent(m.ks) > maxComponent(m.kd) || m.illum == 3:
+		albedo := m.ks
+		if maxComponent(albedo) == 0 {
+			albedo = m.kd
+		}
+		// a Phong exponent Ns roughly matches a roughness of sqrt(2/(Ns+2))
+		return NewMetal(albedo, Clamp(math.Sqrt(2/(m.ns+2)), 0, 1))
+	case m.mapKd != nil:
+		return NewLambertian(m.mapKd)
+	}
+	return NewLambertian(NewSolidColor(m.kd[0], m.kd[1], m.kd[2]))
+}
+
+func maxComponent(c Color) float64 {
+	return math.Max(c[0], math.Max(c[1], c[2]))
+}
+
+func (l *objLoader) readMTL(name string) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return l.errorf("%v", err)
+	}
+	defer f.Close()
+
+	mtl := &objLoader{file: name, dir: filepath.Dir(name)}
+	var cur *mtlMaterial
+	finish := func() error {
+		if cur == nil {
+			return nil
+		}
+		if len(l.mats) > math.MaxUint16 {
+			return mtl.errorf("too many materials")
+		}
+		l.materials[cur.name] = uint16(len(l.mats))
+		l.mats = append(l.mats, cur.material())
+		return nil
+	}
+
+	err = scanStatements(f, &mtl.line, func(keyword string, args []string, rest string) error {
+		if keyword == "newmtl" {
+			if err := finish(); err != nil {
+				return err
+			}
+			cur = &mtlMaterial{name: rest, kd: NewVec3(0.8, 0.8, 0.8), ns: 10, ni: 1.5, d: 1, illum: 2}
+			return nil
+		}
+		if cur == nil {
+			switch keyword {
+			case "Kd", "Ks", "Ke", "Ns", "Ni", "d", "Tr", "illum", "map_Kd":
+				return mtl.errorf("%s before newmtl", keyword)
+			}
+			return nil
+		}
+
+		var err error
+		switch keyword {
+		case "Kd":
+			cur.kd, err = mtl.color(args)
+		case "Ks":
+			cur.ks, err = mtl.color(args)
+		case "Ke":
+			cur.ke, err = mtl.color(args)
+		case "Ns":
+			cur.ns, err = mtl.float(args)
+		case "Ni":
+			cur.ni, err = mtl.float(args)
+		case "d":
+			cur.d, err = mtl.float(args)
+		case "Tr":
+			var tr float64
+			tr, err = mtl.float(args)
+			cur.d = 1 - tr
+		case "illum":
+			var illum float64
+			illum, err = mtl.float(args)
+			cur.illum = int(illum)
+		case "map_Kd":
+			cur.mapKd, err = l.texture(mtl, args)
+		}
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return finish()
+}
+
+func (l *objLoader) color(args []string) (Color, error) {
+	if len(args) == 1 {
+		// a single value is a grey
+		args = []string{args[0], args[0], args[0]}
+	}
+	v, err := l.floats(args, 3)
+	if err != nil {
+		return Zero(), err
+	}
+	return NewVec3(v[0], v[1], v[2]), nil
+}
+
+func (l *objLoader) float(args []string) (float64, error) {
+	v, err := l.floats(args, 1)
+	if err != nil {
+		return 0, err
+	}
+	return v[0], nil
+}
+
+// texture loads the image of a map_ statement. The -o, -s and -clamp options
+// set the offset, scale and wrap of the texture coordinates and other options
+// are skipped; the rest of the line is the file name, which may contain
+// spaces.
+func (l *objLoader) texture(mtl *objLoader, args []string) (Texture, error) {
+	scale, offset := NewVec2(1, 1), NewVec2(0, 0)
+	wrap := TextureWrapRepeat
+	for len(args) > 0 && strings.HasPrefix(args[0], "-") {
+		n := 1
+		switch args[0] {
+		case "-mm":
+			n = 2
+		case "-o", "-s", "-t":
+			n = 0
+			for n < 3 && n+1 < len(args) {
+				if _, err := strconv.ParseFloat(args[n+1], 64); err != nil {
+					break
+				}
+				n++
+			}
+		}
+		if n+1 > len(args) {
+			return nil, mtl.errorf("option %s is missing its value", args[0])
+		}
+
+		switch args[0] {
+		case "-o", "-s":
+			v := &offset
+			if args[0] == "-s" {
+				v = &scale
+			}
+			// only u and v matter for an image
+			for i := 0; i < n && i < 2; i++ {
+				v[i], _ = strconv.ParseFloat(args[i+1], 64)
+			}
+		case "-clamp":
+			if args[1] == "on" {
+				wrap = TextureWrapClamp
+			}
+		}
+		args = args[n+1:]
+	}
+	if len(args) == 0 {
+		return nil, mtl.errorf("missing texture file name")
+	}
+
+	name := filepath.Join(mtl.dir, filepath.FromSlash(strings.Join(args, " ")))
+	img, ok := l.textures[name]
+	if !ok {
+		var err error
+		if img, err = LoadImageTexture(name); err != nil {
+			return nil, mtl.errorf("%v", err)
+		}
+		l.textures[name] = img
+	}
+
+	// the image is shared between the maps that use it
+	tex := *img
+	tex.Scale, tex.Offset = scale, offset
+	tex.WrapU, tex.WrapV = wrap, wrap
+	return &tex, nil
+}
+
+// scanStatements calls fn for every statement of an OBJ or MTL file, keeping
+// line up to date. Comments are stripped and lines ending in a backslash are
+// joined with the next one.
+func scanStatements(f *os.File, line *int, fn func(keyword string, args []string, rest string) error) error {
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+
+	statement := ""
+	start := 0
+	*line = 0
+	for scanner.Scan() {
+		*line++
+		text := scanner.Text()
+		if statement == "" {
+			start = *line
+		}
+		if strings.HasSuffix(text, "\\") {
+			statement += text[:len(text)-1] + " "
+			continue
+		}
+		statement += text
+
+		if i := strings.IndexByte(statement, '#'); i >= 0 {
+			statement = statement[:i]
+		}
+		fields := strings.Fields(statement)
+		statement = ""
+		if len(fields) == 0 {
+			continue
+		}
+
+		end := *line
+		*line = start
+		rest := strings.Join(fields[1:], " ")
+		if err := fn(fields[0], fields[1:], rest); err != nil {
+			return err
+		}
+		*line = end
+	}
+	return scanner.Err()
+}
+
+// triangulate splits a simple planar polygon into triangles by ear clipping,
+// keeping the winding of the polygon. It falls back to a fan for whatever is
+// left if the polygon turns out not to be simple.
+func triangulate(points []Point3) [][3]int {
+	if len(points) == 3 {
+		return [][3]int{{0, 1, 2}}
+	}
+
+	// project onto the plane of the largest component of the Newell normal
+	var normal Vec3
+	for i := range points {
+		a, b := points[i], points[(i+1)%len(points)]
+		normal[0] += (a[1] - b[1]) * (a[2] + b[2])
+		normal[1] += (a[2] - b[2]) * (a[0] + b[0])
+		normal[2] += (a[0] - b[0]) * (a[1] + b[1])
+	}
+	axis := 0
+	if math.Abs(normal[1]) > math.Abs(normal[axis]) {
+		axis = 1
+	}
+	if math.Abs(normal[2]) > math.Abs(normal[axis]) {
+		axis = 2
+	}
+	ax, ay := (axis+1)%3, (axis+2)%3
+	sign := 1.0
+	if normal[axis] < 0 {
+		sign = -1
+	}
+
+	cross := func(a, b, c int) float64 {
+		pa, pb, pc := points[a], points[b], points[c]
+		return sign * ((pb[ax]-pa[ax])*(pc[ay]-pa[ay]) - (pb[ay]-pa[ay])*(pc[ax]-pa[ax]))
+	}
+
+	remaining := make([]int, len(points))
+	for i := range remaining {
+		remaining[i] = i
+	}
+
+	triangles := make([][3]int, 0, len(points)-2)
+	for len(remaining) > 3 {
+		n := len(remaining)
+		clipped := false
+		for i := 0; i < n; i++ {
+			a, b, c := remaining[(i+n-1)%n], remaining[i], remaining[(i+1)%n]
+			if cross(a, b, c) <= 0 {
+				continue
+			}
+
+			ear := true
+			for _, p := range remaining {
+				if p == a || p == b || p == c {
+					continue
+				}
+				if cross(a, b, p) >= 0 && cross(b, c, p) >= 0 && cross(c, a, p) >= 0 {
+					ear = false
+					break
+				}
+			}
+			if !ear {
+				continue
+			}
+
+			triangles = append(triangles, [3]int{a, b, c})
+			remaining = append(remaining[:i], remaining[i+1:]...)
+			clipped = true
+			break
+		}
+		if !clipped {
+			break
+		}
+	}
+
+	for i := 1; i+1 < len(remaining); i++ {
+		triangles = append(triangles, [3]int{remaining[0], remaining[i], remaining[i+1]})
+	}
+	return triangles
+}
@@ -0,0 +1,51 @@
+package nakitu
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadOBJMalformed(t *testing.T) {
+	const square = "v 0 0 0\nv 1 0 0\nv 1 1 0\nv 0 1 0\n"
+
+	tests := []struct {
+		name string
+		obj  string
+		mtl  string
+		want string
+	}{
+		{name: "empty", obj: "", want: "no faces"},
+		{name: "missing coordinate", obj: "v 0 0\nv 1 0 0\nv 1 1 0\nf 1 2 3\n", want: "a.obj:1:"},
+		{name: "bad number", obj: "v 0 x 0\n", want: "a.obj:1:"},
+		{name: "too few face vertices", obj: square + "f 1 2\n", want: "a.obj:5:"},
+		{name: "vertex index zero", obj: square + "f 0 1 2\n", want: "a.obj:5:"},
+		{name: "vertex index out of range", obj: square + "f 1 2 9\n", want: "a.obj:5:"},
+		{name: "negative index out of range", obj: square + "f -1 -2 -9\n", want: "a.obj:5:"},
+		{name: "oversized index", obj: square + "f 1 2 99999999999999999999\n", want: "a.obj:5:"},
+		{name: "texture index out of range", obj: square + "vt 0 0\nf 1/1 2/2 3/3\n", want: "a.obj:6:"},
+		{name: "normal index out of range", obj: square + "vn 0 0 1\nf 1//1 2//1 3//2\n", want: "a.obj:6:"},
+		{name: "missing library", obj: "mtllib missing.mtl\n" + square + "f 1 2 3\n", want: "missing.mtl"},
+		{name: "bad library colour", obj: "mtllib a.mtl\n" + square + "f 1 2 3\n", mtl: "newmtl m\nKd 1 x 1\n", want: "a.mtl"},
+		{name: "library statement before newmtl", obj: "mtllib a.mtl\n" + square + "f 1 2 3\n", mtl: "Kd 1 1 1\n", want: "a.mtl"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			name := filepath.Join(dir, "a.obj")
+			if err := ioutil.WriteFile(name, []byte(tt.obj), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if tt.mtl != "" {
+				if err := ioutil.WriteFile(filepath.Join(dir, "a.mtl"), []byte(tt.mtl), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			loadFails(t, tt.want, func() error {
+				_, err := LoadOBJ(name)
+				return err
+			})
+		})
+	}
+}
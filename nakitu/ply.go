@@ -0,0 +1,458 @@
+package nakitu
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// LoadPLY reads a PLY mesh in ASCII or binary format. Vertex positions,
+// normals, texture coordinates and colours are picked up when present and
+// polygons are triangulated; other elements and properties are skipped. The
+// file is streamed, so only the mesh itself is kept in memory.
+//
+// The mesh gets a single Lambertian material, showing the vertex colours if
+// the file has any. Integer colours are taken to be sRGB encoded, like the
+// texels of an ImageTexture, and float colours to be linear.
+func LoadPLY(name string) (*TriangleMesh, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	p := &plyReader{r: bufio.NewReaderSize(f, 1<<16)}
+	mesh, err := p.read()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	return mesh, nil
+}
+
+// plyMaxReserve caps the room reserved up front for an element, since the
+// count in the header may not match the data that follows.
+const plyMaxReserve = 1 << 20
+
+func plyReserve(count int) int {
+	if count > plyMaxReserve {
+		return plyMaxReserve
+	}
+	return count
+}
+
+type plyFormat int
+
+const (
+	plyASCII plyFormat = iota
+	plyBinaryLittleEndian
+	plyBinaryBigEndian
+)
+
+type plyElement struct {
+	name       string
+	count      int
+	properties []plyProperty
+}
+
+type plyProperty struct {
+	name string
+	typ  string
+	// countType is set for list properties
+	countType string
+}
+
+type plyReader struct {
+	r      *bufio.Reader
+	format plyFormat
+	order  binary.ByteOrder
+	buf    [8]byte
+	// line counts header lines and, for ASCII files, data lines
+	line   int
+	inData bool
+}
+
+func (p *plyReader) errorf(format string, args ...interface{}) error {
+	if p.inData && p.format != plyASCII {
+		return fmt.Errorf(format, args...)
+	}
+	return fmt.Errorf("line %d: %s", p.line, fmt.Sprintf(format, args...))
+}
+
+func (p *plyReader) read() (*TriangleMesh, error) {
+	elements, err := p.header()
+	if err != nil {
+		return nil, err
+	}
+
+	mesh := &TriangleMesh{}
+	for _, e := range elements {
+		switch e.name {
+		case "vertex":
+			err = p.vertices(e, mesh)
+		case "face":
+			err = p.faces(e, mesh)
+		default:
+			err = p.skip(e)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", e.name, err)
+		}
+	}
+
+	tex := Texture(NewSolidColor(0.8, 0.8, 0.8))
+	if mesh.Colors != nil {
+		tex = NewVertexColorTexture(tex)
+	}
+	mesh.Materials = []Material{NewLambertian(tex)}
+
+	if err := mesh.Build(); err != nil {
+		return nil, err
+	}
+	return mesh, nil
+}
+
+func (p *plyReader) header() ([]*plyElement, error) {
+	var elements []*plyElement
+	for {
+		line, err := p.r.ReadString('\n')
+		if err != nil {
+			if err == io.EOF {
+				err = fmt.Errorf("unexpected end of header")
+			}
+			return nil, err
+		}
+		p.line++
+
+		fields := strings.Fields(line)
+		if p.line == 1 {
+			if len(fields) != 1 || fields[0] != "ply" {
+				return nil, fmt.Errorf("not a PLY file")
+			}
+			continue
+		}
+		if len(fields) == 0 {
+			continue
+		}
+
+		switch fields[0] {
+		case "format":
+			if len(fields) != 3 {
+				return nil, p.errorf("malformed format line")
+			}
+			switch fields[1] {
+			case "ascii":
+				p.format = plyASCII
+			case "binary_little_endian":
+				p.format, p.order = plyBinaryLittleEndian, binary.LittleEndian
+			case "binary_big_endian":
+				p.format, p.order = plyBinaryBigEndian, binary.BigEndian
+			default:
+				return nil, p.errorf("unknown format %q", fields[1])
+			}
+		case "element":
+			if len(fields) != 3 {
+				return nil, p.errorf("malformed element line")
+			}
+			count, err := strconv.Atoi(fields[2])
+			if err != nil || count < 0 {
+				return nil, p.errorf("invalid element count %q", fields[2])
+			}
+			elements = append(elements, &plyElement{name: fields[1], count: count})
+		case "property":
+			if len(elements) == 0 {
+				return nil, p.errorf("property before any element")
+			}
+			e := elements[len(elements)-1]
+			var prop plyProperty
+			switch {
+			case len(fields) == 3:
+				prop = plyProperty{typ: fields[1], name: fields[2]}
+			case len(fields) == 5 && fields[1] == "list":
+				prop = plyProperty{countType: fields[2], typ: fields[3], name: fields[4]}
+				if plyTypeSize(prop.countType) == 0 {
+					return nil, p.errorf("unknown type %q", prop.countType)
+				}
+			default:
+				return nil, p.errorf("malformed property line")
+			}
+			if plyTypeSize(prop.typ) == 0 {
+				return nil, p.errorf("unknown type %q", prop.typ)
+			}
+			e.properties = append(e.properties, prop)
+		case "end_header":
+			p.inData = true
+			p.line++
+			return elements, nil
+		case "comment", "obj_info":
+		default:
+			return nil, p.errorf("unknown header keyword %q", fields[0])
+		}
+	}
+}
+
+// plyTypeSize is the size of a scalar type in bytes, or 0 for an unknown one.
+func plyTypeSize(typ string) int {
+	switch typ {
+	case "char", "uchar", "int8", "uint8":
+		return 1
+	case "short", "ushort", "int16", "uint16":
+		return 2
+	case "int", "uint", "float", "int32", "uint32", "float32":
+		return 4
+	case "double", "float64":
+		return 8
+	}
+	return 0
+}
+
+// value reads one scalar of the given type.
+func (p *plyReader) value(typ string) (float64, error) {
+	if p.format == plyASCII {
+		return p.word()
+	}
+
+	b := p.buf[:plyTypeSize(typ)]
+	if _, err := io.ReadFull(p.r, b); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return 0, err
+	}
+
+	switch typ {
+	case "char", "int8":
+		return float64(int8(b[0])), nil
+	case "uchar", "uint8":
+		return float64(b[0]), nil
+	case "short", "int16":
+		return float64(int16(p.order.Uint16(b))), nil
+	case "ushort", "uint16":
+		return float64(p.order.Uint16(b)), nil
+	case "int", "int32":
+		return float64(int32(p.order.Uint32(b))), nil
+	case "uint", "uint32":
+		return float64(p.order.Uint32(b)), nil
+	case "float", "float32":
+		return float64(math.Float32frombits(p.order.Uint32(b))), nil
+	}
+	return math.Float64frombits(p.order.Uint64(b)), nil
+}
+
+// word reads the next number of an ASCII file.
+func (p *plyReader) word() (float64, error) {
+	var sb strings.Builder
+	for {
+		c, err := p.r.ReadByte()
+		if err != nil {
+			if err == io.EOF && sb.Len() > 0 {
+				break
+			}
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return 0, err
+		}
+		if c == ' ' || c == '\t' || c == '\r' || c == '\n' {
+			if sb.Len() > 0 {
+				// leave the newline to the next word so that errors point at
+				// the line of the bad number
+				p.r.UnreadByte()
+				break
+			}
+			if c == '\n' {
+				p.line++
+			}
+			continue
+		}
+		sb.WriteByte(c)
+	}
+
+	x, err := strconv.ParseFloat(sb.String(), 64)
+	if err != nil {
+		return 0, p.errorf("invalid number %q", sb.String())
+	}
+	return x, nil
+}
+
+// property reads a scalar property, or a list into list.
+func (p *plyReader) property(prop plyProperty, list []float64) (float64, []float64, error) {
+	if prop.countType == "" {
+		x, err := p.value(prop.typ)
+		return x, list, err
+	}
+
+	n, err := p.value(prop.countType)
+	if err != nil {
+		return 0, list, err
+	}
+	if n < 0 || n != math.Trunc(n) {
+		return 0, list, p.errorf("invalid list length %v", n)
+	}
+
+	list = list[:0]
+	for i := 0; i < int(n); i++ {
+		x, err := p.value(prop.typ)
+		if err != nil {
+			return 0, list, err
+		}
+		list = append(list, x)
+	}
+	return 0, list, nil
+}
+
+func (p *plyReader) vertices(e *plyElement, mesh *TriangleMesh) error {
+	// slots of the attributes we know: x y z nx ny nz red green blue u v
+	const (
+		slotX = iota
+		slotNX
+		slotRed
+		slotU
+	)
+	slots := make([]int, len(e.properties))
+	var has [4]bool
+	colorScale := 1.0
+	srgb := false
+	for i, prop := range e.properties {
+		slots[i] = -1
+		if prop.countType != "" {
+			continue
+		}
+		switch prop.name {
+		case "x", "y", "z":
+			slots[i] = slotX*3 + int(prop.name[0]-'x')
+			has[slotX] = true
+		case "nx", "ny", "nz":
+			slots[i] = slotNX*3 + int(prop.name[1]-'x')
+			has[slotNX] = true
+		case "red", "green", "blue", "r", "g", "b", "diffuse_red", "diffuse_green", "diffuse_blue":
+			slots[i] = slotRed*3 + strings.IndexByte("rgb", strings.TrimPrefix(prop.name, "diffuse_")[0])
+			has[slotRed] = true
+			if prop.typ == "uchar" || prop.typ == "uint8" {
+				colorScale = 1.0 / 255
+				srgb = true
+			} else if prop.typ == "ushort" || prop.typ == "uint16" {
+				colorScale = 1.0 / 65535
+				srgb = true
+			}
+		case "u", "s", "texture_u", "texture_s":
+			slots[i] = slotU * 3
+			has[slotU] = true
+		case "v", "t", "texture_v", "texture_t":
+			slots[i] = slotU*3 + 1
+			has[slotU] = true
+		}
+	}
+	if !has[slotX] {
+		return fmt.Errorf("missing x, y and z properties")
+	}
+
+	n := plyReserve(e.count)
+	mesh.Positions = make([]Point3, 0, n)
+	if has[slotNX] {
+		mesh.Normals = make([]Vec3, 0, n)
+	}
+	if has[slotRed] {
+		mesh.Colors = make([]Color, 0, n)
+	}
+	if has[slotU] {
+		mesh.UVs = make([]Vec2, 0, n)
+	}
+
+	var values [12]float64
+	var list []float64
+	for v := 0; v < e.count; v++ {
+		for i, prop := range e.properties {
+			x, l, err := p.property(prop, list)
+			list = l
+			if err != nil {
+				return fmt.Errorf("vertex %d: %v", v, err)
+			}
+			if slots[i] >= 0 {
+				values[slots[i]] = x
+			}
+		}
+
+		mesh.Positions = append(mesh.Positions, NewVec3(values[0], values[1], values[2]))
+		if mesh.Normals != nil {
+			mesh.Normals = append(mesh.Normals, NewVec3(values[3], values[4], values[5]))
+		}
+		if mesh.Colors != nil {
+			c := NewVec3(values[6], values[7], values[8]).Mulf(colorScale)
+			if srgb {
+				c = NewVec3(SRGBToLinear(c[0]), SRGBToLinear(c[1]), SRGBToLinear(c[2]))
+			}
+			mesh.Colors = append(mesh.Colors, c)
+		}
+		if mesh.UVs != nil {
+			mesh.UVs = append(mesh.UVs, NewVec2(values[9], values[10]))
+		}
+	}
+	return nil
+}
+
+func (p *plyReader) faces(e *plyElement, mesh *TriangleMesh) error {
+	indexProp := -1
+	for i, prop := range e.properties {
+		if prop.countType != "" && (prop.name == "vertex_indices" || prop.name == "vertex_index") {
+			indexProp = i
+		}
+	}
+	if indexProp < 0 {
+		return fmt.Errorf("missing vertex_indices property")
+	}
+
+	mesh.Indices = make([]uint32, 0, 3*plyReserve(e.count))
+	var list []float64
+	var points []Point3
+	for f := 0; f < e.count; f++ {
+		for i, prop := range e.properties {
+			var err error
+			if _, list, err = p.property(prop, list); err != nil {
+				return fmt.Errorf("face %d: %v", f, err)
+			}
+			if i != indexProp {
+				continue
+			}
+
+			if len(list) < 3 {
+				return fmt.Errorf("face %d: %d vertices, need at least 3", f, len(list))
+			}
+			for _, x := range list {
+				if x < 0 || int(x) >= len(mesh.Positions) {
+					return fmt.Errorf("face %d: vertex index %v out of range", f, x)
+				}
+			}
+			if len(list) == 3 {
+				mesh.Indices = append(mesh.Indices, uint32(list[0]), uint32(list[1]), uint32(list[2]))
+				continue
+			}
+
+			points = points[:0]
+			for _, x := range list {
+				points = append(points, mesh.Positions[int(x)])
+			}
+			for _, tri := range triangulate(points) {
+				mesh.Indices = append(mesh.Indices, uint32(list[tri[0]]), uint32(list[tri[1]]), uint32(list[tri[2]]))
+			}
+		}
+	}
+	return nil
+}
+
+func (p *plyReader) skip(e *plyElement) error {
+	var list []float64
+	for i := 0; i < e.count; i++ {
+		for _, prop := range e.properties {
+			var err error
+			if _, list, err = p.property(prop, list); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
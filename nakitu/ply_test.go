@@ -0,0 +1,85 @@
+package nakitu
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadPLYMalformed(t *testing.T) {
+	const (
+		header = "ply\nformat ascii 1.0\n"
+		vertex = "element vertex 3\nproperty float x\nproperty float y\nproperty float z\n"
+		face   = "element face 1\nproperty list uchar int vertex_indices\n"
+		data   = "0 0 0\n1 0 0\n0 1 0\n3 0 1 2\n"
+	)
+
+	tests := []struct {
+		name string
+		ply  string
+		want string
+	}{
+		{name: "empty", ply: "", want: "end of header"},
+		{name: "not a PLY file", ply: "plx\n", want: "not a PLY file"},
+		{name: "no end of header", ply: header + vertex, want: "end of header"},
+		{name: "unknown format", ply: "ply\nformat binary_middle_endian 1.0\n", want: "unknown format"},
+		{name: "negative count", ply: header + "element vertex -1\n", want: "invalid element count"},
+		{name: "oversized vertex count", ply: header + "element vertex 100000000000000000\nproperty float x\nproperty float y\nproperty float z\nend_header\n0 0 0\n", want: "vertex 1"},
+		{name: "oversized face count", ply: header + vertex + "element face 100000000000000000\nproperty list uchar int vertex_indices\nend_header\n" + data, want: "face 1"},
+		{name: "unknown type", ply: header + "element vertex 1\nproperty float128 x\n", want: "unknown type"},
+		{name: "property before element", ply: header + "property float x\n", want: "property before any element"},
+		{name: "no positions", ply: header + "element vertex 1\nproperty float q\nend_header\n0\n", want: "missing x, y and z"},
+		{name: "bad number", ply: header + vertex + face + "end_header\n0 0 0\n1 x 0\n0 1 0\n3 0 1 2\n", want: "invalid number"},
+		{name: "truncated vertices", ply: header + vertex + face + "end_header\n0 0 0\n1 0\n", want: "vertex"},
+		{name: "vertex index out of range", ply: header + vertex + face + "end_header\n0 0 0\n1 0 0\n0 1 0\n3 0 1 7\n", want: "out of range"},
+		{name: "too few face vertices", ply: header + vertex + face + "end_header\n0 0 0\n1 0 0\n0 1 0\n2 0 1\n", want: "need at least 3"},
+		{name: "negative list length", ply: header + vertex + "element face 1\nproperty list char int vertex_indices\nend_header\n0 0 0\n1 0 0\n0 1 0\n-3 0 1 2\n", want: "invalid list length"},
+		{name: "faces before vertices", ply: header + face + vertex + "end_header\n3 0 1 2\n" + "0 0 0\n1 0 0\n0 1 0\n", want: "out of range"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			name := filepath.Join(t.TempDir(), "a.ply")
+			if err := ioutil.WriteFile(name, []byte(tt.ply), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			loadFails(t, tt.want, func() error {
+				_, err := LoadPLY(name)
+				return err
+			})
+		})
+	}
+}
+
+func TestLoadPLYTruncated(t *testing.T) {
+	var ply bytes.Buffer
+	ply.WriteString("ply\nformat binary_little_endian 1.0\n" +
+		"element vertex 3\nproperty float x\nproperty float y\nproperty float z\nproperty uchar red\nproperty uchar green\nproperty uchar blue\n" +
+		"element face 1\nproperty list uchar uint vertex_indices\nend_header\n")
+	for _, v := range [][3]float32{{0, 0, 0}, {1, 0, 0}, {0, 1, 0}} {
+		binary.Write(&ply, binary.LittleEndian, v)
+		ply.Write([]byte{255, 128, 0})
+	}
+	ply.WriteByte(3)
+	binary.Write(&ply, binary.LittleEndian, [3]uint32{0, 1, 2})
+
+	name := filepath.Join(t.TempDir(), "a.ply")
+	if err := ioutil.WriteFile(name, ply.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadPLY(name); err != nil {
+		t.Fatalf("whole file: %v", err)
+	}
+
+	for n := 0; n < ply.Len(); n++ {
+		if err := ioutil.WriteFile(name, ply.Bytes()[:n], 0644); err != nil {
+			t.Fatal(err)
+		}
+		loadFails(t, "a.ply", func() error {
+			_, err := LoadPLY(name)
+			return err
+		})
+	}
+}
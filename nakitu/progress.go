@@ -0,0 +1,37 @@
+package nakitu
+
+import "time"
+
+// Progress is reported to Scene.OnProgress after every finished tile.
+type Progress struct {
+	Pass       int
+	Passes     int
+	TilesDone  int
+	TilesTotal int
+	Samples    int64
+	Elapsed    time.Duration
+	ETA        time.Duration
+}
+
+func (p Progress) Fraction() float64 {
+	if p.TilesTotal == 0 {
+		return 1
+	}
+	return float64(p.TilesDone) / float64(p.TilesTotal)
+}
+
+func newProgress(passes, tilesTotal int, start time.Time) Progress {
+	return Progress{
+		Passes:     passes,
+		TilesTotal: tilesTotal,
+		Elapsed:    time.Since(start),
+	}
+}
+
+func (p *Progress) update(pass, samples int, start time.Time) {
+	p.Pass = pass
+	p.TilesDone++
+	p.Samples += int64(samples)
+	p.Elapsed = time.Since(start)
+	p.ETA = time.Duration(float64(p.Elapsed) * float64(p.TilesTotal-p.TilesDone) / float64(p.TilesDone))
+}
@@ -0,0 +1,42 @@
+package nakitu
+
+// Rand is a small splitmix64 generator. Each pixel gets its own stream derived
+// from the scene seed, so a render does not depend on how work is scheduled.
+type Rand struct {
+	state uint64
+}
+
+func NewRand(seed uint64) *Rand {
+	return &Rand{state: seed}
+}
+
+func NewPixelRand(seed uint64, x, y, sample int) *Rand {
+	h := mix64(seed + 0x9e3779b97f4a7c15)
+	h = mix64(h ^ uint64(x))
+	h = mix64(h ^ uint64(y))
+	h = mix64(h ^ uint64(sample))
+	return NewRand(h)
+}
+
+func (r *Rand) Uint64() uint64 {
+	r.state += 0x9e3779b97f4a7c15
+	return mix64(r.state)
+}
+
+func (r *Rand) Float64() float64 {
+	return float64(r.Uint64()>>11) / (1 << 53)
+}
+
+func (r *Rand) Random(min, max float64) float64 {
+	return min + (max-min)*r.Float64()
+}
+
+func (r *Rand) Intn(n int) int {
+	return int(r.Uint64() % uint64(n))
+}
+
+func mix64(z uint64) uint64 {
+	z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+	z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+	return z ^ (z >> 31)
+}
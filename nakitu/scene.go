@@ -1,25 +1,43 @@
 package nakitu
 
 import (
-	"bufio"
+	"context"
+	"io"
 	"math"
-	"math/rand"
 	"os"
+	"runtime"
 	"sync"
-
-	pb "github.com/cheggaaa/pb/v3"
+	"time"
 )
 
+// rouletteDepth is the number of bounces after which paths may be ended by
+// Russian roulette.
+const rouletteDepth = 3
+
 type Scene struct {
 	Width           int
 	Height          int
 	Background      Color
 	SamplesPerPixel int
+	SamplesPerPass  int
 	MaxDepth        int
 	World           Hittable
+	Lights          *LightList
 	Camera          *Camera
+	Seed            uint64
+	Threads         int
+	TileSize        int
+	TileOrder       TileOrder
+	ToneMapper      ToneMapper
+	Exposure        float64
+	Adaptive        *AdaptiveSampling
 
+	Buffer *FrameBuffer
 	Output *Image
+
+	OnPass     func(pass int)
+	OnProgress func(p Progress)
+	TileStats  []TileStat
 }
 
 func NewScene(width, height int, world Hittable, camera *Camera) *Scene {
@@ -28,104 +46,325 @@ func NewScene(width, height int, world Hittable, camera *Camera) *Scene {
 		Height:          height,
 		Background:      NewVec3(0, 0, 0),
 		SamplesPerPixel: 10,
-		MaxDepth:        6,
+		MaxDepth:        50,
 		World:           world,
+		Lights:          CollectLights(world),
 		Camera:          camera,
+		Threads:         runtime.NumCPU(),
+		TileSize:        32,
+		TileOrder:       TileOrderScanline,
+		ToneMapper:      NewLinearToneMapper(),
+		Buffer:          NewFrameBuffer(width, height),
 		Output:          NewImage(width, height),
 	}
 }
 
-func (s *Scene) WriteToFile(name string) {
-	f, _ := os.Create(name)
-	buf := bufio.NewWriter(f)
-	s.Output.Write(buf)
-	buf.Flush()
-	f.Close()
+// Resize changes the image size and discards anything rendered so far.
+func (s *Scene) Resize(width, height int) {
+	s.Width = width
+	s.Height = height
+	s.Buffer = NewFrameBuffer(width, height)
+	s.Output = NewImage(width, height)
+}
+
+func (s *Scene) Encode(w io.Writer, format Format) error {
+	if format.IsHDR() {
+		return s.Buffer.Encode(w, format)
+	}
+	return s.Resolve().Encode(w, format)
 }
 
-func (s *Scene) Render() {
-	bar := pb.StartNew(s.Height)
+// Resolve tone maps the accumulated radiance into Output.
+func (s *Scene) Resolve() *Image {
+	scale := math.Exp2(s.Exposure)
 	for y := 0; y < s.Height; y++ {
 		for x := 0; x < s.Width; x++ {
-			s.RenderPixel(x, y)
+			c := s.ToneMapper.Map(s.Buffer.Pixel(x, y).Mulf(scale))
+			s.Output.SetPixel(x, y, toRGB(c))
 		}
-		bar.Increment()
 	}
-	bar.Finish()
+	return s.Output
+}
+
+func (s *Scene) WriteToFile(name string) error {
+	format, err := FormatFromName(name)
+	if err != nil {
+		return err
+	}
+
+	return s.WriteToFileAs(name, format)
+}
+
+func (s *Scene) WriteToFileAs(name string, format Format) error {
+	if name == "-" {
+		return s.Encode(os.Stdout, format)
+	}
+
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+
+	if err := s.Encode(f, format); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
 }
 
-func (s *Scene) RenderParallel(numOfCore int) {
-	lines := make(chan int)
-	go func() {
-		for y := 0; y < s.Height; y++ {
-			lines <- y
+// Render adds samples until every pixel has SamplesPerPixel of them or ctx is
+// done. On cancellation the samples taken so far stay in Buffer and
+// ctx.Err() is returned.
+func (s *Scene) Render(ctx context.Context) error {
+	tiles := s.Tiles()
+	passes := s.remainingPasses()
+
+	threads := s.Threads
+	if threads <= 0 {
+		threads = runtime.NumCPU()
+	}
+
+	start := time.Now()
+	progress := newProgress(passes, len(tiles)*passes, start)
+	s.TileStats = s.TileStats[:0]
+	mu := sync.Mutex{}
+
+	for pass := 0; pass < passes && s.needsSamples(); pass++ {
+		queue := make(chan Tile, len(tiles))
+		for _, tile := range tiles {
+			queue <- tile
 		}
-		close(lines)
-	}()
-
-	wg := sync.WaitGroup{}
-	bar := pb.StartNew(s.Height)
-
-	for i := 0; i < numOfCore; i++ {
-		wg.Add(1)
-		go func() {
-			for y := range lines {
-				for x := 0; x < s.Width; x++ {
-					s.RenderPixel(x, y)
+		close(queue)
+
+		wg := sync.WaitGroup{}
+		for i := 0; i < threads; i++ {
+			wg.Add(1)
+			go func(worker int) {
+				for tile := range queue {
+					if ctx.Err() != nil {
+						break
+					}
+
+					tileStart := time.Now()
+					samples := s.RenderTile(tile)
+					stat := TileStat{
+						Tile:     tile,
+						Pass:     pass,
+						Worker:   worker,
+						Samples:  samples,
+						Duration: time.Since(tileStart),
+					}
+
+					mu.Lock()
+					s.TileStats = append(s.TileStats, stat)
+					progress.update(pass, samples, start)
+					if s.OnProgress != nil {
+						s.OnProgress(progress)
+					}
+					mu.Unlock()
 				}
-				bar.Increment()
-			}
-			wg.Done()
-		}()
+				wg.Done()
+			}(i)
+		}
+
+		wg.Wait()
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		s.finishPass(pass)
 	}
 
-	wg.Wait()
-	bar.Finish()
+	return nil
 }
 
-func (s *Scene) RenderPixel(x, y int) {
+func (s *Scene) RenderParallel(ctx context.Context, numOfCore int) error {
+	s.Threads = numOfCore
+	return s.Render(ctx)
+}
+
+func (s *Scene) RenderTile(tile Tile) int {
+	samples := 0
+	for iy := tile.Y0; iy < tile.Y1; iy++ {
+		for ix := tile.X0; ix < tile.X1; ix++ {
+			samples += s.RenderPixel(s.Width-ix-1, s.Height-iy-1)
+		}
+	}
+	return samples
+}
+
+// samplesPerPass is SamplesPerPass or, if that is unset, the whole budget in
+// one pass. Adaptive sampling only looks at the pixels between passes, so
+// with it the default is MinSamples at a time instead.
+func (s *Scene) samplesPerPass() int {
+	perPass := s.SamplesPerPass
+	if perPass <= 0 && s.Adaptive != nil {
+		perPass = s.Adaptive.MinSamples
+		if perPass <= 0 {
+			perPass = 1
+		}
+	}
+	if perPass <= 0 || perPass > s.maxSamples() {
+		return s.maxSamples()
+	}
+	return perPass
+}
+
+// remainingPasses counts the passes needed to bring every pixel up to its
+// maximum sample count, taking samples already in Buffer into account. With
+// adaptive sampling this is an upper bound.
+func (s *Scene) remainingPasses() int {
+	done := s.maxSamples()
+	for _, n := range s.Buffer.Samples {
+		if n < done {
+			done = n
+		}
+	}
+
+	perPass := s.samplesPerPass()
+	if perPass <= 0 {
+		return 0
+	}
+	return (s.maxSamples() - done + perPass - 1) / perPass
+}
+
+func (s *Scene) finishPass(pass int) {
+	if s.OnPass != nil {
+		s.OnPass(pass)
+	}
+}
+
+// RenderPixel adds one pass worth of samples to a pixel. The random stream
+// is keyed on the samples already taken, so resumed renders continue it.
+func (s *Scene) RenderPixel(x, y int) int {
+	ix, iy := s.Width-x-1, s.Height-y-1
+	index := ix + iy*s.Width
+	done := s.Buffer.Samples[index]
+
+	samples := s.samplesPerPass()
+	if budget := s.pixelBudget(index); samples > budget {
+		samples = budget
+	}
+	if samples <= 0 {
+		return 0
+	}
+
 	sumColor := Zero()
+	sumSquares := 0.0
+	rnd := NewPixelRand(s.Seed, x, y, done)
 
-	for i := 0; i < s.SamplesPerPixel; i++ {
-		u := (float64(x) + rand.Float64()) / float64(s.Width-1)
-		v := (float64(y) + rand.Float64()) / float64(s.Height-1)
-		r := s.Camera.GetRay(u, v)
-		color := rayColor(r, s.Background, s.World, s.MaxDepth)
+	for i := 0; i < samples; i++ {
+		u := (float64(x) + rnd.Float64()) / float64(s.Width-1)
+		v := (float64(y) + rnd.Float64()) / float64(s.Height-1)
+		r := s.Camera.GetRay(u, v, rnd)
+		color := rayColor(r, s.Background, s.World, s.Lights, s.MaxDepth, rnd)
 		sumColor = sumColor.Add(color)
+		l := Luminance(color)
+		sumSquares += l * l
 	}
 
-	rgb := toRGB(sumColor, s.SamplesPerPixel)
-	s.Output.SetPixel(s.Width-x-1, s.Height-y-1, rgb)
+	s.Buffer.Add(ix, iy, sumColor, sumSquares, samples)
+	return samples
 }
 
-func rayColor(r *Ray, background Color, world Hittable, depth int) Color {
+// rayColor estimates the radiance arriving along r. At surfaces that are not
+// specular it samples both a light and the BSDF and weights the two with the
+// power heuristic. After rouletteDepth bounces paths are ended at random,
+// with a chance that follows their throughput, and the survivors are
+// reweighted so that the estimate stays unbiased; maxDepth only caps the
+// length of paths that keep surviving.
+func rayColor(r *Ray, background Color, world Hittable, lights *LightList, maxDepth int, rnd *Rand) Color {
 	var rec HitRecord
+	var s BSDFSample
 
-	if depth <= 0 {
-		return Zero()
+	radiance := Zero()
+	throughput := NewVec3(1, 1, 1)
+	ray := *r
+	// bsdfPDF is the density with which the last bounce picked ray, or 0
+	// for the camera ray and specular bounces, and weights the light it finds
+	bsdfPDF := 0.0
+
+	for depth := 0; depth < maxDepth; depth++ {
+		if !world.Hit(&ray, 0.001, math.Inf(1), &rec, rnd) {
+			radiance = radiance.Add(throughput.Mul(background))
+			break
+		}
+
+		emitted := rec.Mat.Emitted(rec.U, rec.V, rec.Point)
+		if bsdfPDF > 0 && emitted != Zero() {
+			emitted = emitted.Mulf(powerHeuristic(bsdfPDF, lights.PDFValue(ray.Origin, ray.Dir, rnd)))
+		}
+		radiance = radiance.Add(throughput.Mul(emitted))
+
+		if !rec.Mat.Sample(&ray, &rec, &s, rnd) {
+			break
+		}
+		if s.Specular {
+			bsdfPDF = 0
+		} else {
+			if s.PDF <= 0 {
+				break
+			}
+			// the light found by the last bounce is cut off by the cap, so
+			// only sample lights directly where the BSDF sample can still
+			// reach them
+			if depth+1 < maxDepth {
+				radiance = radiance.Add(throughput.Mul(sampleLight(&ray, &rec, world, lights, rnd)))
+			}
+			bsdfPDF = s.PDF
+		}
+		throughput = throughput.Mul(s.Weight())
+
+		if depth+1 >= rouletteDepth {
+			survive := math.Min(maxComponent(throughput), 0.95)
+			if survive <= 0 || rnd.Float64() >= survive {
+				break
+			}
+			throughput = throughput.Divf(survive)
+		}
+
+		ray = Ray{Origin: rec.Point, Dir: s.Dir, Time: ray.Time}
 	}
 
-	if !world.Hit(r, 0.001, math.Inf(1), &rec) {
-		return background
+	return radiance
+}
+
+// sampleLight casts a shadow ray toward a random point on one of the lights
+// and returns the light reflected back along r, weighted against the chance
+// of the BSDF sampling the same direction.
+func sampleLight(r *Ray, rec *HitRecord, world Hittable, lights *LightList, rnd *Rand) Color {
+	if len(lights.Lights) == 0 {
+		return Zero()
 	}
 
-	var scattered Ray
-	var attenuation Color
-	emitted := rec.Mat.Emitted(rec.U, rec.V, rec.Point)
+	dir := lights.SampleDirection(rec.Point, rnd)
+	lightPDF := lights.PDFValue(rec.Point, dir, rnd)
+	if lightPDF <= 0 {
+		return Zero()
+	}
+	f := rec.Mat.Eval(r, rec, dir)
+	if f == Zero() {
+		return Zero()
+	}
 
-	if !rec.Mat.Scatter(r, &rec, &attenuation, &scattered) {
-		return emitted
+	// anything in the way, including a participating medium that scatters
+	// the shadow ray, leaves the light unseen
+	var shadow HitRecord
+	if !world.Hit(NewRay(rec.Point, dir, r.Time), 0.001, math.Inf(1), &shadow, rnd) {
+		return Zero()
+	}
+	emitted := shadow.Mat.Emitted(shadow.U, shadow.V, shadow.Point)
+	if emitted == Zero() {
+		return Zero()
 	}
 
-	c := rayColor(&scattered, background, world, depth-1)
-	return emitted.Add(attenuation.Mul(c))
+	weight := powerHeuristic(lightPDF, rec.Mat.PDF(r, rec, dir))
+	return f.Mul(emitted).Mulf(weight / lightPDF)
 }
 
-func toRGB(color Vec3, samplesPerPixel int) RGB {
+func toRGB(color Vec3) RGB {
 	toColor := func(x float64) int {
-		scale := 1.0 / float64(samplesPerPixel)
-		scaledX := math.Sqrt(x * scale)
-		return int(256 * Clamp(scaledX, 0, 0.999))
+		return int(256 * Clamp(LinearToSRGB(x), 0, 0.999))
 	}
 
 	return NewRGB(
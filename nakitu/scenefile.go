@@ -0,0 +1,1099 @@
+package nakitu
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// LoadScene reads a JSON scene description. Errors carry the file name, the
+// line and the path of the offending field, e.g.
+//
+//	cornell.json:12: materials.light.emit[1]: expected a number, got string
+func LoadScene(name string) (*Scene, error) {
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	return ParseScene(name, data)
+}
+
+// ParseScene decodes a scene description. Relative file references are
+// resolved against the directory of name.
+func ParseScene(name string, data []byte) (*Scene, error) {
+	root, err := parseJSON(name, data)
+	if err != nil {
+		return nil, err
+	}
+
+	l := &sceneLoader{
+		file:         name,
+		dir:          filepath.Dir(name),
+		textureDefs:  map[string]*jsonNode{},
+		materialDefs: map[string]*jsonNode{},
+		geometryDefs: map[string]*jsonNode{},
+		textures:     map[string]Texture{},
+		materials:    map[string]Material{},
+		geometries:   map[string]Hittable{},
+		resolving:    map[string]bool{},
+		gltfs:        map[string]*GLTFScene{},
+		images:       map[imageKey]*ImageTexture{},
+	}
+
+	scene := l.scene(root)
+	if l.err != nil {
+		return nil, l.err
+	}
+	return scene, nil
+}
+
+type sceneLoader struct {
+	file string
+	dir  string
+	err  error
+
+	textureDefs  map[string]*jsonNode
+	materialDefs map[string]*jsonNode
+	geometryDefs map[string]*jsonNode
+	textures     map[string]Texture
+	materials    map[string]Material
+	geometries   map[string]Hittable
+	resolving    map[string]bool
+	gltfs        map[string]*GLTFScene
+	images       map[imageKey]*ImageTexture
+}
+
+// imageKey identifies a cached image; each layout of the texels is kept once.
+type imageKey struct {
+	file  string
+	tiled bool
+}
+
+// fail records the first error; later calls are ignored so that decoding can
+// carry on with zero values.
+func (l *sceneLoader) fail(node *jsonNode, field, format string, args ...interface{}) {
+	if l.err != nil {
+		return
+	}
+	l.err = &jsonError{File: l.file, Line: node.Line, Field: field, Msg: fmt.Sprintf(format, args...)}
+}
+
+func (l *sceneLoader) path(name string) string {
+	if filepath.IsAbs(name) {
+		return name
+	}
+	return filepath.Join(l.dir, name)
+}
+
+func (l *sceneLoader) scene(root *jsonNode) *Scene {
+	top := l.fields(root, "")
+
+	image := l.fields(top.required("image"), "image")
+	camera := l.fields(top.required("camera"), "camera")
+
+	if defs := top.get("textures"); defs != nil {
+		l.definitions(defs, "textures", l.textureDefs)
+	}
+	if defs := top.get("materials"); defs != nil {
+		l.definitions(defs, "materials", l.materialDefs)
+	}
+	if defs := top.get("geometry"); defs != nil {
+		l.definitions(defs, "geometry", l.geometryDefs)
+	}
+
+	world := NewHittableList()
+	world.Objects = top.requiredHittables("objects")
+	top.done()
+
+	width := image.requiredInt("width")
+	aspectRatio := image.float("aspect_ratio", 16.0/9.0)
+	height := image.int("height", int(float64(width)/aspectRatio))
+	if l.err == nil && (width <= 1 || height <= 1) {
+		l.fail(image.node, "image", "image must be at least 2x2 pixels, got %dx%d", width, height)
+		return nil
+	}
+
+	cam := l.camera(camera, float64(width)/float64(height))
+	if cam == nil {
+		return nil
+	}
+	cam.Time0 = camera.float("time0", 0)
+	cam.Time1 = camera.float("time1", 0)
+	camera.done()
+
+	scene := NewScene(width, height, world, cam)
+	scene.Background = image.vec3("background", scene.Background)
+	scene.SamplesPerPixel = image.positiveInt("samples_per_pixel", scene.SamplesPerPixel)
+	scene.SamplesPerPass = image.int("samples_per_pass", scene.SamplesPerPass)
+	scene.MaxDepth = image.positiveInt("max_depth", scene.MaxDepth)
+	scene.Seed = uint64(image.int("seed", 0))
+	scene.Exposure = image.float("exposure", 0)
+	scene.TileSize = image.positiveInt("tile_size", scene.TileSize)
+	if node := image.get("tile_order"); node != nil {
+		scene.TileOrder = l.tileOrder(node, "image.tile_order")
+	}
+	if node := image.get("tone_mapper"); node != nil {
+		scene.ToneMapper = l.toneMapper(node, "image.tone_mapper")
+	}
+	if node := image.get("adaptive"); node != nil {
+		adaptive := l.fields(node, "image.adaptive")
+		minSamples := adaptive.positiveInt("min_samples", 16)
+		maxSamples := adaptive.positiveInt("max_samples", 4*scene.SamplesPerPixel)
+		if minSamples > maxSamples {
+			// blame whichever of the two was set, or the object if neither was
+			node, field := adaptive.node, "image.adaptive"
+			for _, key := range []string{"max_samples", "min_samples"} {
+				if n := adaptive.get(key); n != nil {
+					node, field = n, adaptive.field(key)
+				}
+			}
+			l.fail(node, field, "min_samples %d is larger than max_samples %d", minSamples, maxSamples)
+		}
+		scene.Adaptive = NewAdaptiveSampling(minSamples, maxSamples, adaptive.float("threshold", 0.01))
+		adaptive.done()
+	}
+	image.done()
+
+	return scene
+}
+
+// camera reads either an explicit camera or, with "gltf", one of the cameras
+// of a glTF file picked by "index".
+func (l *sceneLoader) camera(camera *jsonFields, aspectRatio float64) *Camera {
+	if node := camera.get("gltf"); node != nil {
+		index := camera.int("index", 0)
+		gltf := l.gltf(node, camera.field("gltf"), l.string(node, camera.field("gltf")))
+		if gltf == nil {
+			return nil
+		}
+		if index < 0 || index >= len(gltf.Cameras) {
+			l.fail(node, camera.field("index"), "camera %d not found, the file has %d perspective cameras", index, len(gltf.Cameras))
+			return nil
+		}
+
+		p := gltf.Cameras[index]
+		p.AspectRatio = aspectRatio
+		p.Aperture = camera.float("aperture", 0)
+		p.FocusDist = camera.float("focus_dist", p.FocusDist)
+		return NewCameraFromParams(p)
+	}
+
+	lookFrom := camera.requiredVec3("look_from")
+	lookAt := camera.requiredVec3("look_at")
+	return NewCamera(
+		lookFrom,
+		lookAt,
+		camera.vec3("up", NewVec3(0, 1, 0)),
+		camera.float("vfov", 40),
+		aspectRatio,
+		camera.float("aperture", 0),
+		camera.float("focus_dist", lookFrom.Sub(lookAt).Len()),
+	)
+}
+
+// gltf loads a glTF file once, however often the scene refers to it.
+func (l *sceneLoader) gltf(node *jsonNode, path, name string) *GLTFScene {
+	if l.err != nil {
+		return nil
+	}
+
+	file := l.path(name)
+	if gltf, ok := l.gltfs[file]; ok {
+		return gltf
+	}
+	gltf, err := LoadGLTF(file)
+	if err != nil {
+		l.fail(node, path, "%v", err)
+		return nil
+	}
+	l.gltfs[file] = gltf
+	return gltf
+}
+
+func (l *sceneLoader) definitions(node *jsonNode, path string, defs map[string]*jsonNode) {
+	if node.Kind != jsonObject {
+		l.fail(node, path, "expected an object of named definitions, got %v", node.Kind)
+		return
+	}
+	for _, key := range node.Keys {
+		defs[key] = node.Fields[key]
+	}
+}
+
+func (l *sceneLoader) tileOrder(node *jsonNode, path string) TileOrder {
+	order, err := ParseTileOrder(l.string(node, path))
+	if err != nil {
+		l.fail(node, path, "%v", err)
+	}
+	return order
+}
+
+func (l *sceneLoader) textureFilter(node *jsonNode, path string) TextureFilter {
+	filter, err := ParseTextureFilter(l.string(node, path))
+	if err != nil {
+		l.fail(node, path, "%v", err)
+	}
+	return filter
+}
+
+func (l *sceneLoader) textureWrap(node *jsonNode, path string) TextureWrap {
+	wrap, err := ParseTextureWrap(l.string(node, path))
+	if err != nil {
+		l.fail(node, path, "%v", err)
+	}
+	return wrap
+}
+
+func (l *sceneLoader) textureProjection(node *jsonNode, path string) TextureProjection {
+	projection, err := ParseTextureProjection(l.string(node, path))
+	if err != nil {
+		l.fail(node, path, "%v", err)
+	}
+	return projection
+}
+
+func (l *sceneLoader) toneMapper(node *jsonNode, path string) ToneMapper {
+	if node.Kind == jsonString {
+		tm, err := ParseToneMapper(node.String)
+		if err != nil {
+			l.fail(node, path, "%v", err)
+			return NewLinearToneMapper()
+		}
+		return tm
+	}
+
+	f := l.fields(node, path)
+	defer f.done()
+
+	kind := f.requiredString("type")
+	switch kind {
+	case "reinhard":
+		return NewReinhardToneMapper(f.float("white", math.Inf(1)))
+	case "uncharted2":
+		return NewUncharted2ToneMapper(f.float("white", 5.6))
+	}
+
+	tm, err := ParseToneMapper(kind)
+	if err != nil {
+		l.fail(f.get("type"), path+".type", "%v", err)
+		return NewLinearToneMapper()
+	}
+	return tm
+}
+
+// texture accepts the name of a texture definition, an [r, g, b] colour or an
+// inline texture object.
+func (l *sceneLoader) texture(node *jsonNode, path string) Texture {
+	switch node.Kind {
+	case jsonString:
+		if tex, ok := l.textures[node.String]; ok {
+			return tex
+		}
+		def, ok := l.textureDefs[node.String]
+		if !ok {
+			l.fail(node, path, "undefined texture %q", node.String)
+			return NewSolidColor(0, 0, 0)
+		}
+		if l.resolving[node.String] {
+			l.fail(node, path, "texture %q refers to itself", node.String)
+			return NewSolidColor(0, 0, 0)
+		}
+		l.resolving[node.String] = true
+		tex := l.texture(def, "textures."+node.String)
+		delete(l.resolving, node.String)
+		l.textures[node.String] = tex
+		return tex
+	case jsonArray:
+		c := l.vec3(node, path)
+		return NewSolidColor(c[0], c[1], c[2])
+	case jsonObject:
+	default:
+		l.fail(node, path, "expected a texture name, colour or object, got %v", node.Kind)
+		return NewSolidColor(0, 0, 0)
+	}
+
+	f := l.fields(node, path)
+	defer f.done()
+
+	kind := f.requiredString("type")
+	switch kind {
+	case "solid":
+		c := f.requiredVec3("color")
+		return NewSolidColor(c[0], c[1], c[2])
+	case "checker":
+		return NewCheckerTexture(f.requiredTexture("odd"), f.requiredTexture("even"))
+	case "vertex_color":
+		fallback := Texture(NewSolidColor(0.8, 0.8, 0.8))
+		if f.get("fallback") != nil {
+			fallback = f.requiredTexture("fallback")
+		}
+		return NewVertexColorTexture(fallback)
+	case "noise":
+		tex := NewNoiseTexture(l.perlin(f), f.float("scale", 1))
+		tex.Ramp = f.ramp("ramp")
+		return tex
+	case "turbulence":
+		tex := NewTurbulenceTexture(l.perlin(f), f.float("scale", 1))
+		tex.Octaves = f.positiveInt("octaves", tex.Octaves)
+		tex.Ramp = f.ramp("ramp")
+		return tex
+	case "marble":
+		tex := NewMarbleTexture(l.perlin(f), f.float("scale", 1))
+		tex.Turbulence = f.float("turbulence", tex.Turbulence)
+		tex.Octaves = f.positiveInt("octaves", tex.Octaves)
+		tex.Ramp = f.ramp("ramp")
+		return tex
+	case "wood":
+		tex := NewWoodTexture(l.perlin(f), f.float("scale", 1))
+		tex.Turbulence = f.float("turbulence", tex.Turbulence)
+		tex.Octaves = f.positiveInt("octaves", tex.Octaves)
+		if ramp := f.ramp("ramp"); ramp != nil {
+			tex.Ramp = ramp
+		}
+		return tex
+	case "worley":
+		tex := NewWorleyTexture(uint64(f.int("seed", 0)), f.float("scale", 1))
+		tex.Ramp = f.ramp("ramp")
+		return tex
+	case "image":
+		name, node := f.requiredString("file"), f.get("file")
+		if node == nil {
+			return NewSolidColor(0, 0, 0)
+		}
+		tex := l.image(node, path+".file", l.path(name), f.bool("tiled", false))
+		if tex == nil {
+			return NewSolidColor(0, 0, 0)
+		}
+		if node := f.get("filter"); node != nil {
+			tex.Filter = l.textureFilter(node, f.field("filter"))
+		}
+		if node := f.get("wrap"); node != nil {
+			tex.WrapU = l.textureWrap(node, f.field("wrap"))
+			tex.WrapV = tex.WrapU
+		}
+		if node := f.get("projection"); node != nil {
+			tex.Projection = l.textureProjection(node, f.field("projection"))
+		}
+		if scale := f.get("scale"); scale != nil && scale.Kind == jsonNumber {
+			tex.Scale = NewVec2(scale.Number, scale.Number)
+		} else {
+			tex.Scale = f.vec2("scale", tex.Scale)
+		}
+		tex.Offset = f.vec2("offset", tex.Offset)
+		tex.Rotation = f.float("rotation", 0)
+		return tex
+	}
+	l.fail(f.get("type"), path+".type", "unknown texture type %q", kind)
+	return NewSolidColor(0, 0, 0)
+}
+
+// image loads an image file once and returns a texture of its own that
+// shares the texels with the other textures of the same file and layout.
+func (l *sceneLoader) image(node *jsonNode, path, file string, tiled bool) *ImageTexture {
+	key := imageKey{file, tiled}
+	img, ok := l.images[key]
+	if !ok {
+		if other, ok := l.images[imageKey{file, !tiled}]; ok {
+			// lay out the texels again rather than decode the file again
+			relaid := *other
+			img = &relaid
+		} else {
+			var err error
+			if img, err = LoadImageTexture(file); err != nil {
+				l.fail(node, path, "%v", err)
+				return nil
+			}
+		}
+		img.SetTiled(tiled)
+		l.images[key] = img
+	}
+
+	tex := *img
+	return &tex
+}
+
+// perlin reads the seed and interpolation of a noise texture.
+func (l *sceneLoader) perlin(f *jsonFields) *Perlin {
+	noise := NewPerlin(uint64(f.int("seed", 0)))
+	if node := f.get("interpolation"); node != nil {
+		path := f.field("interpolation")
+		interpolation, err := ParsePerlinInterpolation(l.string(node, path))
+		if err != nil {
+			l.fail(node, path, "%v", err)
+		}
+		noise.Interpolation = interpolation
+	}
+	return noise
+}
+
+// ramp accepts an array of {"position": x, "color": [r, g, b]} stops.
+func (l *sceneLoader) ramp(node *jsonNode, path string) ColorRamp {
+	items := l.array(node, path)
+	if node.Kind == jsonArray && len(items) == 0 {
+		l.fail(node, path, "expected at least one colour stop")
+		return nil
+	}
+
+	stops := make([]ColorStop, len(items))
+	for i, item := range items {
+		f := l.fields(item, fmt.Sprintf("%s[%d]", path, i))
+		stops[i] = ColorStop{Pos: f.requiredFloat("position"), Color: f.requiredVec3("color")}
+		f.done()
+	}
+	return NewColorRamp(stops...)
+}
+
+// material accepts the name of a material definition or an inline material
+// object.
+func (l *sceneLoader) material(node *jsonNode, path string) Material {
+	switch node.Kind {
+	case jsonString:
+		if mat, ok := l.materials[node.String]; ok {
+			return mat
+		}
+		def, ok := l.materialDefs[node.String]
+		if !ok {
+			l.fail(node, path, "undefined material %q", node.String)
+			return NewLambertian(NewSolidColor(0, 0, 0))
+		}
+		key := "materials." + node.String
+		if l.resolving[key] {
+			l.fail(node, path, "material %q refers to itself", node.String)
+			return NewLambertian(NewSolidColor(0, 0, 0))
+		}
+		l.resolving[key] = true
+		mat := l.material(def, key)
+		delete(l.resolving, key)
+		l.materials[node.String] = mat
+		return mat
+	case jsonObject:
+	default:
+		l.fail(node, path, "expected a material name or object, got %v", node.Kind)
+		return NewLambertian(NewSolidColor(0, 0, 0))
+	}
+
+	f := l.fields(node, path)
+	defer f.done()
+
+	kind := f.requiredString("type")
+	switch kind {
+	case "lambertian":
+		return NewLambertian(f.requiredTexture("albedo"))
+	case "metal":
+		return NewMetal(f.requiredVec3("albedo"), f.float("fuzz", 0))
+	case "dielectric":
+		return NewDielectric(f.requiredFloat("ir"))
+	case "diffuse_light":
+		return NewDiffuseLight(f.requiredTexture("emit"))
+	case "isotropic":
+		return NewIsotropic(f.requiredTexture("albedo"))
+	}
+	l.fail(f.get("type"), path+".type", "unknown material type %q", kind)
+	return NewLambertian(NewSolidColor(0, 0, 0))
+}
+
+// geometry resolves the name of a shared geometry definition. Each definition
+// is built once however many instances use it.
+func (l *sceneLoader) geometry(node *jsonNode, path string) Hittable {
+	if node == nil {
+		return nil
+	}
+	name := l.string(node, path)
+	if geometry, ok := l.geometries[name]; ok {
+		return geometry
+	}
+	def, ok := l.geometryDefs[name]
+	if !ok {
+		if l.err == nil {
+			l.fail(node, path, "undefined geometry %q", name)
+		}
+		return nil
+	}
+
+	key := "geometry." + name
+	if l.resolving[key] {
+		l.fail(node, path, "geometry %q refers to itself", name)
+		return nil
+	}
+	l.resolving[key] = true
+	geometry := l.hittable(def, key)
+	delete(l.resolving, key)
+
+	if geometry != nil {
+		var box AABB
+		if !geometry.BoundingBox(0, 1, &box) {
+			l.fail(def, key, "geometry has no bounding box")
+			return nil
+		}
+	}
+	l.geometries[name] = geometry
+	return geometry
+}
+
+func (l *sceneLoader) hittables(node *jsonNode, path string) []Hittable {
+	if node.Kind != jsonArray {
+		l.fail(node, path, "expected an array of objects, got %v", node.Kind)
+		return nil
+	}
+
+	objects := make([]Hittable, 0, len(node.Items))
+	for i, item := range node.Items {
+		if obj := l.hittable(item, fmt.Sprintf("%s[%d]", path, i)); obj != nil {
+			objects = append(objects, obj)
+		}
+	}
+	return objects
+}
+
+func (l *sceneLoader) hittable(node *jsonNode, path string) Hittable {
+	f := l.fields(node, path)
+	defer f.done()
+
+	kind := f.requiredString("type")
+	switch kind {
+	case "sphere":
+		return NewSphere(f.requiredVec3("center"), f.requiredFloat("radius"), f.requiredMaterial("material"))
+	case "moving_sphere":
+		return NewMovingSphere(
+			f.requiredVec3("center0"),
+			f.requiredVec3("center1"),
+			f.float("time0", 0),
+			f.float("time1", 1),
+			f.requiredFloat("radius"),
+			f.requiredMaterial("material"),
+		)
+	case "xy_rect":
+		return NewXYRect(f.requiredFloat("x0"), f.requiredFloat("y0"), f.requiredFloat("x1"), f.requiredFloat("y1"), f.requiredFloat("k"), f.requiredMaterial("material"))
+	case "xz_rect":
+		return NewXZRect(f.requiredFloat("x0"), f.requiredFloat("z0"), f.requiredFloat("x1"), f.requiredFloat("z1"), f.requiredFloat("k"), f.requiredMaterial("material"))
+	case "yz_rect":
+		return NewYZRect(f.requiredFloat("y0"), f.requiredFloat("z0"), f.requiredFloat("y1"), f.requiredFloat("z1"), f.requiredFloat("k"), f.requiredMaterial("material"))
+	case "box":
+		return NewBox(f.requiredVec3("min"), f.requiredVec3("max"), f.requiredMaterial("material"))
+	case "list":
+		list := NewHittableList()
+		list.Objects = f.requiredHittables("objects")
+		return list
+	case "bvh":
+		list := NewHittableList()
+		list.Objects = f.requiredHittables("objects")
+		time0, time1 := f.float("time0", 0), f.float("time1", 1)
+		if l.err != nil {
+			return nil
+		}
+		if len(list.Objects) == 0 {
+			l.fail(node, path+".objects", "a bvh needs at least one object")
+			return nil
+		}
+		for i, obj := range list.Objects {
+			var box AABB
+			if !obj.BoundingBox(time0, time1, &box) {
+				l.fail(f.get("objects").Items[i], fmt.Sprintf("%s.objects[%d]", path, i), "object has no bounding box")
+				return nil
+			}
+		}
+		return NewBVHNode(list, time0, time1)
+	case "triangle":
+		v := f.requiredVec3s("vertices", 3)
+		n := f.vec3s("normals", 3)
+		uv := f.vec2s("uvs", 3)
+		mat := f.requiredMaterial("material")
+		if l.err != nil {
+			return nil
+		}
+		tri := NewTriangle(v[0], v[1], v[2], mat)
+		if n != nil {
+			tri = NewSmoothTriangle(v[0], v[1], v[2], n[0], n[1], n[2], tri.UV0, tri.UV1, tri.UV2, mat)
+		}
+		if uv != nil {
+			tri.UV0, tri.UV1, tri.UV2 = uv[0], uv[1], uv[2]
+		}
+		return tri
+	case "mesh":
+		return l.mesh(node, path, f)
+	case "obj":
+		name, node := f.requiredString("file"), f.get("file")
+		if node == nil || l.err != nil {
+			return nil
+		}
+		obj, err := LoadOBJ(l.path(name))
+		if err != nil {
+			l.fail(node, path+".file", "%v", err)
+			return nil
+		}
+		return obj
+	case "ply":
+		name, node := f.requiredString("file"), f.get("file")
+		var mat Material
+		if f.get("material") != nil {
+			mat = f.requiredMaterial("material")
+		}
+		if node == nil || l.err != nil {
+			return nil
+		}
+		mesh, err := LoadPLY(l.path(name))
+		if err != nil {
+			l.fail(node, path+".file", "%v", err)
+			return nil
+		}
+		if mat != nil {
+			mesh.Materials = []Material{mat}
+		}
+		return mesh
+	case "gltf":
+		name, node := f.requiredString("file"), f.get("file")
+		if node == nil || l.err != nil {
+			return nil
+		}
+		gltf := l.gltf(node, path+".file", name)
+		if gltf == nil {
+			return nil
+		}
+		return gltf.World
+	case "translate":
+		return NewTranslate(f.requiredHittable("object"), f.requiredVec3("offset"))
+	case "rotate_y":
+		obj, angle := f.requiredHittable("object"), f.requiredFloat("angle")
+		if obj == nil {
+			return nil
+		}
+		return NewRotateY(obj, angle)
+	case "transform":
+		obj := f.requiredHittable("object")
+		m := Identity()
+		if node := f.required("steps"); node != nil {
+			m = l.matrix(node, f.field("steps"))
+		}
+		if obj == nil || l.err != nil {
+			return nil
+		}
+		t, err := NewTransform(obj, m)
+		if err != nil {
+			l.fail(node, path, "%v", err)
+			return nil
+		}
+		return t
+	case "instance":
+		geometry := l.geometry(f.required("geometry"), f.field("geometry"))
+		m := Identity()
+		if node := f.get("steps"); node != nil {
+			m = l.matrix(node, f.field("steps"))
+		}
+		var mat Material
+		if f.get("material") != nil {
+			mat = f.requiredMaterial("material")
+		}
+		if geometry == nil || l.err != nil {
+			return nil
+		}
+		instance, err := NewInstance(geometry, m, mat)
+		if err != nil {
+			l.fail(node, path, "%v", err)
+			return nil
+		}
+		return instance
+	case "constant_medium":
+		return NewConstantMedium(f.requiredHittable("boundary"), f.requiredFloat("density"), f.requiredTexture("albedo"))
+	}
+	if node.Kind == jsonObject && f.get("type") != nil {
+		l.fail(f.get("type"), path+".type", "unknown object type %q", kind)
+	}
+	return nil
+}
+
+func (l *sceneLoader) mesh(node *jsonNode, path string, f *jsonFields) Hittable {
+	mesh := &TriangleMesh{}
+	if f.required("positions") != nil {
+		mesh.Positions = f.vec3List("positions")
+	}
+	mesh.Normals = f.vec3List("normals")
+	mesh.UVs = f.vec2List("uvs")
+	if f.required("indices") != nil {
+		mesh.Indices = f.uintList("indices", len(mesh.Positions))
+	}
+
+	if materials := f.get("materials"); materials != nil {
+		for i, item := range l.array(materials, f.field("materials")) {
+			mesh.Materials = append(mesh.Materials, l.material(item, fmt.Sprintf("%s[%d]", f.field("materials"), i)))
+		}
+		for _, id := range f.uintList("material_ids", len(mesh.Materials)) {
+			mesh.MaterialIDs = append(mesh.MaterialIDs, uint16(id))
+		}
+	} else {
+		mesh.Materials = []Material{f.requiredMaterial("material")}
+	}
+	if l.err != nil {
+		return nil
+	}
+
+	if err := mesh.Build(); err != nil {
+		l.fail(node, path, "%v", err)
+		return nil
+	}
+	return mesh
+}
+
+// matrix composes a list of transform steps, each an object with one of
+// translate, scale, rotate, look_at or matrix, applied in order.
+func (l *sceneLoader) matrix(node *jsonNode, path string) Matrix4 {
+	m := Identity()
+	for i, item := range l.array(node, path) {
+		stepPath := fmt.Sprintf("%s[%d]", path, i)
+		f := l.fields(item, stepPath)
+		if len(f.node.Keys) != 1 {
+			l.fail(item, stepPath, "expected exactly one of translate, scale, rotate, look_at or matrix")
+			return m
+		}
+
+		var step Matrix4
+		switch key := f.node.Keys[0]; key {
+		case "translate":
+			step = NewTranslation(f.requiredVec3("translate"))
+		case "scale":
+			if scale := f.get("scale"); scale.Kind == jsonNumber {
+				step = NewScale(NewVec3(scale.Number, scale.Number, scale.Number))
+			} else {
+				step = NewScale(f.requiredVec3("scale"))
+			}
+		case "rotate":
+			rotate := l.fields(f.get("rotate"), f.field("rotate"))
+			axis := rotate.requiredVec3("axis")
+			if l.err == nil && axis.LenSquared() == 0 {
+				l.fail(rotate.get("axis"), rotate.field("axis"), "axis must not be zero")
+			}
+			step = NewRotation(axis, rotate.requiredFloat("angle"))
+			rotate.done()
+		case "look_at":
+			lookAt := l.fields(f.get("look_at"), f.field("look_at"))
+			step = NewLookAt(lookAt.requiredVec3("from"), lookAt.requiredVec3("to"), lookAt.vec3("up", NewVec3(0, 1, 0)))
+			lookAt.done()
+		case "matrix":
+			items := l.array(f.get("matrix"), f.field("matrix"))
+			if items != nil && len(items) != 16 {
+				l.fail(f.get("matrix"), f.field("matrix"), "expected 16 numbers in row order")
+				return m
+			}
+			for j, item := range items {
+				step[j/4][j%4] = l.number(item, fmt.Sprintf("%s[%d]", f.field("matrix"), j))
+			}
+		default:
+			l.fail(f.get(key), f.field(key), "unknown transform step (valid: translate, scale, rotate, look_at, matrix)")
+			return m
+		}
+		if l.err != nil {
+			return m
+		}
+		m = step.Mul(m)
+	}
+
+	if _, ok := m.Inverse(); !ok && l.err == nil {
+		l.fail(node, path, "transform is singular")
+	}
+	return m
+}
+
+func (l *sceneLoader) number(node *jsonNode, path string) float64 {
+	if node.Kind != jsonNumber {
+		l.fail(node, path, "expected a number, got %v", node.Kind)
+		return 0
+	}
+	return node.Number
+}
+
+func (l *sceneLoader) string(node *jsonNode, path string) string {
+	if node.Kind != jsonString {
+		l.fail(node, path, "expected a string, got %v", node.Kind)
+		return ""
+	}
+	return node.String
+}
+
+func (l *sceneLoader) vec3(node *jsonNode, path string) Vec3 {
+	if node.Kind != jsonArray || len(node.Items) != 3 {
+		l.fail(node, path, "expected an array of 3 numbers")
+		return Zero()
+	}
+
+	var v Vec3
+	for i, item := range node.Items {
+		v[i] = l.number(item, fmt.Sprintf("%s[%d]", path, i))
+	}
+	return v
+}
+
+func (l *sceneLoader) vec2(node *jsonNode, path string) Vec2 {
+	if node.Kind != jsonArray || len(node.Items) != 2 {
+		l.fail(node, path, "expected an array of 2 numbers")
+		return Vec2{}
+	}
+
+	var v Vec2
+	for i, item := range node.Items {
+		v[i] = l.number(item, fmt.Sprintf("%s[%d]", path, i))
+	}
+	return v
+}
+
+func (l *sceneLoader) array(node *jsonNode, path string) []*jsonNode {
+	if node.Kind != jsonArray {
+		l.fail(node, path, "expected an array, got %v", node.Kind)
+		return nil
+	}
+	return node.Items
+}
+
+// jsonFields reads the fields of a JSON object and reports unknown ones in
+// done, which catches misspelt keys.
+type jsonFields struct {
+	l    *sceneLoader
+	node *jsonNode
+	path string
+	used map[string]bool
+}
+
+func (l *sceneLoader) fields(node *jsonNode, path string) *jsonFields {
+	f := &jsonFields{l: l, node: node, path: path, used: map[string]bool{}}
+	if node == nil {
+		f.node = &jsonNode{Kind: jsonObject, Fields: map[string]*jsonNode{}}
+	} else if node.Kind != jsonObject {
+		l.fail(node, path, "expected an object, got %v", node.Kind)
+		f.node = &jsonNode{Kind: jsonObject, Line: node.Line, Fields: map[string]*jsonNode{}}
+	}
+	return f
+}
+
+func (f *jsonFields) field(key string) string {
+	if f.path == "" {
+		return key
+	}
+	return f.path + "." + key
+}
+
+func (f *jsonFields) get(key string) *jsonNode {
+	f.used[key] = true
+	return f.node.Fields[key]
+}
+
+func (f *jsonFields) required(key string) *jsonNode {
+	node := f.get(key)
+	if node == nil {
+		f.l.fail(f.node, f.field(key), "missing required field")
+	}
+	return node
+}
+
+func (f *jsonFields) float(key string, def float64) float64 {
+	if node := f.get(key); node != nil {
+		return f.l.number(node, f.field(key))
+	}
+	return def
+}
+
+func (f *jsonFields) bool(key string, def bool) bool {
+	node := f.get(key)
+	if node == nil {
+		return def
+	}
+	if node.Kind != jsonBool {
+		f.l.fail(node, f.field(key), "expected true or false, got %v", node.Kind)
+		return def
+	}
+	return node.Bool
+}
+
+func (f *jsonFields) requiredFloat(key string) float64 {
+	if node := f.required(key); node != nil {
+		return f.l.number(node, f.field(key))
+	}
+	return 0
+}
+
+func (f *jsonFields) int(key string, def int) int {
+	node := f.get(key)
+	if node == nil {
+		return def
+	}
+
+	x := f.l.number(node, f.field(key))
+	if x != math.Trunc(x) {
+		f.l.fail(node, f.field(key), "expected an integer, got %v", x)
+	}
+	return int(x)
+}
+
+func (f *jsonFields) requiredInt(key string) int {
+	if f.required(key) == nil {
+		return 0
+	}
+	return f.int(key, 0)
+}
+
+func (f *jsonFields) positiveInt(key string, def int) int {
+	x := f.int(key, def)
+	if node := f.get(key); node != nil && x <= 0 {
+		f.l.fail(node, f.field(key), "must be positive, got %d", x)
+	}
+	return x
+}
+
+func (f *jsonFields) requiredString(key string) string {
+	if node := f.required(key); node != nil {
+		return f.l.string(node, f.field(key))
+	}
+	return ""
+}
+
+func (f *jsonFields) vec3(key string, def Vec3) Vec3 {
+	if node := f.get(key); node != nil {
+		return f.l.vec3(node, f.field(key))
+	}
+	return def
+}
+
+func (f *jsonFields) requiredVec3(key string) Vec3 {
+	if node := f.required(key); node != nil {
+		return f.l.vec3(node, f.field(key))
+	}
+	return Zero()
+}
+
+func (f *jsonFields) vec2(key string, def Vec2) Vec2 {
+	if node := f.get(key); node != nil {
+		return f.l.vec2(node, f.field(key))
+	}
+	return def
+}
+
+func (f *jsonFields) vec3List(key string) []Vec3 {
+	node := f.get(key)
+	if node == nil {
+		return nil
+	}
+
+	items := f.l.array(node, f.field(key))
+	vs := make([]Vec3, len(items))
+	for i, item := range items {
+		vs[i] = f.l.vec3(item, fmt.Sprintf("%s[%d]", f.field(key), i))
+	}
+	return vs
+}
+
+func (f *jsonFields) vec2List(key string) []Vec2 {
+	node := f.get(key)
+	if node == nil {
+		return nil
+	}
+
+	items := f.l.array(node, f.field(key))
+	vs := make([]Vec2, len(items))
+	for i, item := range items {
+		vs[i] = f.l.vec2(item, fmt.Sprintf("%s[%d]", f.field(key), i))
+	}
+	return vs
+}
+
+// uintList reads an array of integers in [0, max).
+func (f *jsonFields) uintList(key string, max int) []uint32 {
+	node := f.get(key)
+	if node == nil {
+		return nil
+	}
+
+	items := f.l.array(node, f.field(key))
+	xs := make([]uint32, len(items))
+	for i, item := range items {
+		path := fmt.Sprintf("%s[%d]", f.field(key), i)
+		x := f.l.number(item, path)
+		if x != math.Trunc(x) || x < 0 || x >= float64(max) {
+			f.l.fail(item, path, "expected an integer in [0, %d), got %v", max, x)
+			return nil
+		}
+		xs[i] = uint32(x)
+	}
+	return xs
+}
+
+// vec3s reads an array of exactly n vectors.
+func (f *jsonFields) vec3s(key string, n int) []Vec3 {
+	vs := f.vec3List(key)
+	if vs != nil && len(vs) != n {
+		f.l.fail(f.get(key), f.field(key), "expected an array of %d values", n)
+		return nil
+	}
+	return vs
+}
+
+func (f *jsonFields) requiredVec3s(key string, n int) []Vec3 {
+	if f.required(key) == nil {
+		return make([]Vec3, n)
+	}
+	if vs := f.vec3s(key, n); vs != nil {
+		return vs
+	}
+	return make([]Vec3, n)
+}
+
+func (f *jsonFields) vec2s(key string, n int) []Vec2 {
+	vs := f.vec2List(key)
+	if vs != nil && len(vs) != n {
+		f.l.fail(f.get(key), f.field(key), "expected an array of %d values", n)
+		return nil
+	}
+	return vs
+}
+
+func (f *jsonFields) ramp(key string) ColorRamp {
+	if node := f.get(key); node != nil {
+		return f.l.ramp(node, f.field(key))
+	}
+	return nil
+}
+
+func (f *jsonFields) requiredTexture(key string) Texture {
+	if node := f.required(key); node != nil {
+		return f.l.texture(node, f.field(key))
+	}
+	return NewSolidColor(0, 0, 0)
+}
+
+func (f *jsonFields) requiredMaterial(key string) Material {
+	if node := f.required(key); node != nil {
+		return f.l.material(node, f.field(key))
+	}
+	return NewLambertian(NewSolidColor(0, 0, 0))
+}
+
+func (f *jsonFields) requiredHittable(key string) Hittable {
+	if node := f.required(key); node != nil {
+		return f.l.hittable(node, f.field(key))
+	}
+	return nil
+}
+
+func (f *jsonFields) requiredHittables(key string) []Hittable {
+	if node := f.required(key); node != nil {
+		return f.l.hittables(node, f.field(key))
+	}
+	return nil
+}
+
+func (f *jsonFields) done() {
+	for _, key := range f.node.Keys {
+		if !f.used[key] {
+			f.l.fail(f.node.Fields[key], f.field(key), "unknown field (valid here: %s)", strings.Join(f.usedKeys(), ", "))
+			return
+		}
+	}
+}
+
+func (f *jsonFields) usedKeys() []string {
+	keys := make([]string, 0, len(f.used))
+	for key := range f.used {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
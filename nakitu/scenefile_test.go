@@ -0,0 +1,114 @@
+package nakitu
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// loadFails runs load and reports a nil error, a panic or an error that does
+// not mention want as a failure.
+func loadFails(t *testing.T, want string, load func() error) {
+	t.Helper()
+	defer func() {
+		if r := recover(); r != nil {
+			t.Errorf("panic: %v", r)
+		}
+	}()
+
+	err := load()
+	if err == nil {
+		t.Errorf("no error, want one mentioning %q", want)
+		return
+	}
+	if !strings.Contains(err.Error(), want) {
+		t.Errorf("error %q does not mention %q", err, want)
+	}
+}
+
+func TestParseSceneMalformed(t *testing.T) {
+	const frame = `{
+  "image": {"width": 4, "height": 4%s},
+  "camera": {"look_from": [0, 0, 1], "look_at": [0, 0, 0], "vfov": 40},
+  %s
+  "objects": [%s]
+}`
+	sphere := func(mat string) string {
+		return fmt.Sprintf(`{"type": "sphere", "center": [0, 0, 0], "radius": 1, "material": %s}`, mat)
+	}
+	lambertian := `{"type": "lambertian", "albedo": [0.5, 0.5, 0.5]}`
+
+	tests := []struct {
+		name  string
+		image string
+		defs  string
+		objs  string
+		want  string
+	}{
+		{
+			name: "material cycle",
+			defs: `"materials": {"a": "b", "b": "a"},`,
+			objs: sphere(`"a"`),
+			want: "refers to itself",
+		},
+		{
+			name: "material refers to itself",
+			defs: `"materials": {"a": "a"},`,
+			objs: sphere(`"a"`),
+			want: "refers to itself",
+		},
+		{
+			name: "texture cycle",
+			defs: `"textures": {"a": {"type": "checker", "odd": "b", "even": [1, 1, 1]}, "b": "a"},`,
+			objs: sphere(`{"type": "lambertian", "albedo": "a"}`),
+			want: "refers to itself",
+		},
+		{
+			name: "geometry cycle",
+			defs: `"geometry": {"a": {"type": "instance", "geometry": "a"}},`,
+			objs: `{"type": "instance", "geometry": "a"}`,
+			want: "refers to itself",
+		},
+		{
+			name: "undefined material",
+			objs: sphere(`"missing"`),
+			want: `undefined material "missing"`,
+		},
+		{
+			name: "singular transform",
+			objs: fmt.Sprintf(`{"type": "transform", "object": %s, "steps": [{"scale": [1, 0, 1]}]}`, sphere(lambertian)),
+			want: "singular",
+		},
+		{
+			name:  "min samples above max samples",
+			image: `, "adaptive": {"min_samples": 32, "max_samples": 8}`,
+			objs:  sphere(lambertian),
+			want:  "min_samples 32 is larger than max_samples 8",
+		},
+		{
+			name:  "unknown field",
+			image: `, "colour": 1`,
+			objs:  sphere(lambertian),
+			want:  "unknown field",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := fmt.Sprintf(frame, tt.image, tt.defs, tt.objs)
+			loadFails(t, tt.want, func() error {
+				_, err := ParseScene("scene.json", []byte(data))
+				return err
+			})
+		})
+	}
+}
+
+func TestParseSceneTruncated(t *testing.T) {
+	data := `{"image": {"width": 4, "height": 4}, "camera": {"look_from": [0, 0, 1], "look_at": [0, 0, 0]}, "objects": []}`
+	for n := 0; n < len(data); n++ {
+		loadFails(t, "scene.json", func() error {
+			_, err := ParseScene("scene.json", []byte(data[:n]))
+			return err
+		})
+	}
+}
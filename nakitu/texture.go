@@ -1,17 +1,35 @@
 package nakitu
 
 import (
+	"fmt"
 	"image"
-	"image/jpeg"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
 	"log"
 	"math"
 	"os"
+	"sync"
 )
 
 type Texture interface {
 	Value(u, v float64, p Point3) Color
 }
 
+// HitTexture is a Texture that needs more of the hit than the texture
+// coordinates and the point. Materials look it up through textureValue.
+type HitTexture interface {
+	Texture
+	HitValue(rec *HitRecord) Color
+}
+
+func textureValue(t Texture, rec *HitRecord) Color {
+	if ht, ok := t.(HitTexture); ok {
+		return ht.HitValue(rec)
+	}
+	return t.Value(rec.U, rec.V, rec.Point)
+}
+
 type SolidColor struct {
 	ColorValue Color
 }
@@ -47,51 +65,441 @@ func (c *CheckerTexture) Value(u, v float64, p Point3) Color {
 	}
 }
 
+// VertexColorTexture interpolates the vertex colours of the TriangleMesh that
+// was hit. Other surfaces, and meshes without colours, get Fallback.
+type VertexColorTexture struct {
+	Fallback Texture
+}
+
+func NewVertexColorTexture(fallback Texture) *VertexColorTexture {
+	return &VertexColorTexture{
+		Fallback: fallback,
+	}
+}
+
+func (t *VertexColorTexture) Value(u, v float64, p Point3) Color {
+	return t.Fallback.Value(u, v, p)
+}
+
+func (t *VertexColorTexture) HitValue(rec *HitRecord) Color {
+	if rec.Mesh == nil || rec.Mesh.Colors == nil {
+		return textureValue(t.Fallback, rec)
+	}
+
+	i := rec.Mesh.Indices[3*rec.Face : 3*rec.Face+3]
+	c := rec.Mesh.Colors
+	return c[i[0]].Mulf(rec.Bary[0]).
+		Add(c[i[1]].Mulf(rec.Bary[1])).
+		Add(c[i[2]].Mulf(rec.Bary[2]))
+}
+
+// TextureFilter is how an ImageTexture blends the texels around a lookup.
+type TextureFilter int
+
+const (
+	TextureFilterNearest TextureFilter = iota
+	TextureFilterBilinear
+	// TextureFilterBicubic uses a Catmull-Rom spline over 4x4 texels, which
+	// keeps edges sharper than bilinear filtering when magnified.
+	TextureFilterBicubic
+)
+
+func ParseTextureFilter(name string) (TextureFilter, error) {
+	switch name {
+	case "nearest":
+		return TextureFilterNearest, nil
+	case "bilinear":
+		return TextureFilterBilinear, nil
+	case "bicubic":
+		return TextureFilterBicubic, nil
+	}
+	return 0, fmt.Errorf("unknown texture filter %q (want nearest, bilinear or bicubic)", name)
+}
+
+// TextureWrap is what an ImageTexture shows outside [0, 1].
+type TextureWrap int
+
+const (
+	TextureWrapClamp TextureWrap = iota
+	TextureWrapRepeat
+	TextureWrapMirror
+)
+
+func ParseTextureWrap(name string) (TextureWrap, error) {
+	switch name {
+	case "clamp":
+		return TextureWrapClamp, nil
+	case "repeat":
+		return TextureWrapRepeat, nil
+	case "mirror":
+		return TextureWrapMirror, nil
+	}
+	return 0, fmt.Errorf("unknown texture wrap %q (want clamp, repeat or mirror)", name)
+}
+
+// TextureProjection is where an ImageTexture takes its coordinates from.
+type TextureProjection int
+
+const (
+	// TextureProjectionUV uses the texture coordinates of the surface.
+	TextureProjectionUV TextureProjection = iota
+	// The planar projections use two components of the hit point, which
+	// tiles evenly across large or curved ground where the surface
+	// coordinates would bunch up.
+	TextureProjectionXY
+	TextureProjectionXZ
+	TextureProjectionYZ
+)
+
+func ParseTextureProjection(name string) (TextureProjection, error) {
+	switch name {
+	case "uv":
+		return TextureProjectionUV, nil
+	case "xy":
+		return TextureProjectionXY, nil
+	case "xz":
+		return TextureProjectionXZ, nil
+	case "yz":
+		return TextureProjectionYZ, nil
+	}
+	return 0, fmt.Errorf("unknown texture projection %q (want uv, xy, xz or yz)", name)
+}
+
+// imageTileSize is the edge of the square blocks of texels a tiled
+// ImageTexture stores together.
+const imageTileSize = 8
+
+// ImageTexture maps an image onto texture coordinates, with (0, 0) at the
+// bottom left of the image. Lookups first scale the coordinates, rotate them
+// by Rotation degrees about the origin and add Offset, so a repeating image
+// can be tiled across a large surface.
+//
+// The image is converted once into linear RGB float32 texels, either row by
+// row or, after SetTiled, in square tiles so that filtering at any angle
+// stays within a few cache lines.
 type ImageTexture struct {
-	Image  image.Image
+	Name   string
 	Width  int
 	Height int
+
+	Filter       TextureFilter
+	WrapU, WrapV TextureWrap
+	Projection   TextureProjection
+	Scale        Vec2
+	Offset       Vec2
+	Rotation     float64
+
+	pixels []float32
+	tiled  bool
 }
 
 func NewImageTexture(name string) *ImageTexture {
-	f, err := os.Open(name)
+	tex, err := LoadImageTexture(name)
 	if err != nil {
 		log.Fatal(err)
 	}
+	return tex
+}
 
-	img, err := jpeg.Decode(f)
+// LoadImageTexture reads an image in any format the standard library
+// decodes: PNG, JPEG or GIF.
+func LoadImageTexture(name string) (*ImageTexture, error) {
+	f, err := os.Open(name)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
+	}
+	defer f.Close()
+
+	img, _, err := image.Decode(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+
+	tex := NewImageTextureFromImage(img)
+	tex.Name = name
+	return tex, nil
+}
+
+// NewImageTextureFromImage converts img, which is taken to be sRGB encoded,
+// into linear texels. img is not kept.
+func NewImageTextureFromImage(img image.Image) *ImageTexture {
+	bounds := img.Bounds()
+	t := &ImageTexture{
+		Width:  bounds.Dx(),
+		Height: bounds.Dy(),
+		Scale:  NewVec2(1, 1),
+		pixels: make([]float32, 3*bounds.Dx()*bounds.Dy()),
+	}
+
+	lut := srgbTable()
+	for j := 0; j < t.Height; j++ {
+		for i := 0; i < t.Width; i++ {
+			r, g, b, _ := img.At(bounds.Min.X+i, bounds.Min.Y+j).RGBA()
+			k := t.index(i, j)
+			t.pixels[k] = lut[r]
+			t.pixels[k+1] = lut[g]
+			t.pixels[k+2] = lut[b]
+		}
+	}
+	return t
+}
+
+var (
+	srgbOnce  sync.Once
+	srgbToLin []float32
+)
+
+// srgbTable maps every 16-bit sRGB value to linear.
+func srgbTable() []float32 {
+	srgbOnce.Do(func() {
+		srgbToLin = make([]float32, 1<<16)
+		for i := range srgbToLin {
+			srgbToLin[i] = float32(SRGBToLinear(float64(i) / 0xffff))
+		}
+	})
+	return srgbToLin
+}
+
+// SetTiled switches between row by row and tiled storage.
+func (t *ImageTexture) SetTiled(tiled bool) {
+	if tiled == t.tiled {
+		return
 	}
 
-	return &ImageTexture{
-		Image:  img,
-		Width:  img.Bounds().Dx(),
-		Height: img.Bounds().Dy(),
+	old := *t
+	t.tiled = tiled
+	size := 3 * t.Width * t.Height
+	if tiled {
+		tilesX := (t.Width + imageTileSize - 1) / imageTileSize
+		tilesY := (t.Height + imageTileSize - 1) / imageTileSize
+		size = 3 * tilesX * tilesY * imageTileSize * imageTileSize
 	}
+	t.pixels = make([]float32, size)
+	for j := 0; j < t.Height; j++ {
+		for i := 0; i < t.Width; i++ {
+			copy(t.pixels[t.index(i, j):t.index(i, j)+3], old.pixels[old.index(i, j):])
+		}
+	}
+}
+
+func (t *ImageTexture) Tiled() bool {
+	return t.tiled
+}
+
+// MemoryUsage is the size of the texels in bytes.
+func (t *ImageTexture) MemoryUsage() int {
+	return 4 * len(t.pixels)
+}
+
+// index is the position of the red component of texel (i, j) in pixels.
+func (t *ImageTexture) index(i, j int) int {
+	if !t.tiled {
+		return 3 * (j*t.Width + i)
+	}
+
+	tilesX := (t.Width + imageTileSize - 1) / imageTileSize
+	tile := (j/imageTileSize)*tilesX + i/imageTileSize
+	return 3 * (tile*imageTileSize*imageTileSize + (j%imageTileSize)*imageTileSize + i%imageTileSize)
 }
 
 func (t *ImageTexture) Value(u, v float64, p Point3) Color {
-	u = Clamp(u, 0, 1)
-	v = 1 - Clamp(v, 0, 1)
+	switch t.Projection {
+	case TextureProjectionXY:
+		u, v = p.X(), p.Y()
+	case TextureProjectionXZ:
+		u, v = p.X(), p.Z()
+	case TextureProjectionYZ:
+		u, v = p.Y(), p.Z()
+	}
+
+	u, v = u*t.Scale[0], v*t.Scale[1]
+	if t.Rotation != 0 {
+		sin, cos := math.Sincos(Rad(t.Rotation))
+		u, v = u*cos-v*sin, u*sin+v*cos
+	}
+	u, v = u+t.Offset[0], v+t.Offset[1]
+
+	// texel centres are at half-integer positions, and rows run from the
+	// top of the image down
+	x := u * float64(t.Width)
+	y := (1 - v) * float64(t.Height)
+
+	switch t.Filter {
+	case TextureFilterBilinear:
+		return t.bilinear(x-0.5, y-0.5)
+	case TextureFilterBicubic:
+		return t.bicubic(x-0.5, y-0.5)
+	}
+	return t.texel(int(math.Floor(x)), int(math.Floor(y)))
+}
+
+func (t *ImageTexture) bilinear(x, y float64) Color {
+	fx, fy := math.Floor(x), math.Floor(y)
+	i, j := int(fx), int(fy)
+	dx, dy := x-fx, y-fy
+
+	top := t.texel(i, j).Mulf(1 - dx).Add(t.texel(i+1, j).Mulf(dx))
+	bottom := t.texel(i, j+1).Mulf(1 - dx).Add(t.texel(i+1, j+1).Mulf(dx))
+	return top.Mulf(1 - dy).Add(bottom.Mulf(dy))
+}
 
-	i := int(u * float64(t.Width))
-	j := int(v * float64(t.Height))
+func (t *ImageTexture) bicubic(x, y float64) Color {
+	fx, fy := math.Floor(x), math.Floor(y)
+	i, j := int(fx), int(fy)
+	wx, wy := catmullRom(x-fx), catmullRom(y-fy)
 
-	if i >= t.Width {
-		i = t.Width - 1
+	c := Zero()
+	for n := 0; n < 4; n++ {
+		row := Zero()
+		for m := 0; m < 4; m++ {
+			row = row.Add(t.texel(i+m-1, j+n-1).Mulf(wx[m]))
+		}
+		c = c.Add(row.Mulf(wy[n]))
 	}
-	if j >= t.Height {
-		j = t.Height - 1
+
+	// the spline overshoots next to sharp edges
+	return NewVec3(math.Max(c[0], 0), math.Max(c[1], 0), math.Max(c[2], 0))
+}
+
+// catmullRom returns the weights of the texels at -1, 0, 1 and 2 for a
+// lookup at f in [0, 1).
+func catmullRom(f float64) [4]float64 {
+	return [4]float64{
+		((-0.5*f+1)*f - 0.5) * f,
+		(1.5*f-2.5)*f*f + 1,
+		((-1.5*f+2)*f + 0.5) * f,
+		(0.5*f - 0.5) * f * f,
 	}
+}
+
+// texel reads the texel at column i and row j, wrapped into the image.
+func (t *ImageTexture) texel(i, j int) Color {
+	i = wrapTexel(i, t.Width, t.WrapU)
+	j = wrapTexel(j, t.Height, t.WrapV)
 
-	c := t.Image.At(i, j)
-	r, g, b, _ := c.RGBA()
+	k := t.index(i, j)
+	c := t.pixels[k : k+3 : k+3]
+	return NewVec3(float64(c[0]), float64(c[1]), float64(c[2]))
+}
 
-	colorScale := 1.0 / 0xffff
-	return NewVec3(
-		float64(r)*colorScale,
-		float64(g)*colorScale,
-		float64(b)*colorScale,
-	)
+func wrapTexel(i, n int, wrap TextureWrap) int {
+	switch wrap {
+	case TextureWrapRepeat:
+		i %= n
+		if i < 0 {
+			i += n
+		}
+	case TextureWrapMirror:
+		period := 2 * n
+		i %= period
+		if i < 0 {
+			i += period
+		}
+		if i >= n {
+			i = period - 1 - i
+		}
+	default:
+		if i < 0 {
+			i = 0
+		}
+		if i >= n {
+			i = n - 1
+		}
+	}
+	return i
+}
+
+// ImageTextures finds the image textures used by the materials in world.
+// Textures that share their texels are listed once.
+func ImageTextures(world Hittable) []*ImageTexture {
+	w := &textureWalker{seen: map[interface{}]bool{}}
+	w.hittable(world)
+	return w.images
+}
+
+type textureWalker struct {
+	seen   map[interface{}]bool
+	images []*ImageTexture
+}
+
+func (w *textureWalker) hittable(h Hittable) {
+	if h == nil || w.seen[h] {
+		return
+	}
+	w.seen[h] = true
+
+	switch h := h.(type) {
+	case *HittableList:
+		for _, obj := range h.Objects {
+			w.hittable(obj)
+		}
+	case *BVHNode:
+		for _, obj := range h.objects {
+			w.hittable(obj)
+		}
+	case *Box:
+		w.hittable(h.Sides)
+	case *Translate:
+		w.hittable(h.Obj)
+	case *RotateY:
+		w.hittable(h.Obj)
+	case *Transform:
+		w.hittable(h.Obj)
+	case *Instance:
+		w.hittable(h.Obj)
+		w.material(h.Mat)
+	case *ConstantMedium:
+		w.hittable(h.Boundary)
+		w.material(h.PhaseFunction)
+	case *TriangleMesh:
+		for _, mat := range h.Materials {
+			w.material(mat)
+		}
+	case *Sphere:
+		w.material(h.Mat)
+	case *MovingSphere:
+		w.material(h.Mat)
+	case *XYRect:
+		w.material(h.Mat)
+	case *XZRect:
+		w.material(h.Mat)
+	case *YZRect:
+		w.material(h.Mat)
+	case *Triangle:
+		w.material(h.Mat)
+	}
+}
+
+func (w *textureWalker) material(m Material) {
+	switch m := m.(type) {
+	case *Lambertian:
+		w.texture(m.Albedo)
+	case *DiffuseLight:
+		w.texture(m.Emit)
+	case *Isotropic:
+		w.texture(m.Albedo)
+	}
+}
+
+func (w *textureWalker) texture(t Texture) {
+	if t == nil || w.seen[t] {
+		return
+	}
+	w.seen[t] = true
+
+	switch t := t.(type) {
+	case *ImageTexture:
+		if len(t.pixels) == 0 || w.seen[&t.pixels[0]] {
+			return
+		}
+		w.seen[&t.pixels[0]] = true
+		w.images = append(w.images, t)
+	case *CheckerTexture:
+		w.texture(t.Odd)
+		w.texture(t.Even)
+	case *VertexColorTexture:
+		w.texture(t.Fallback)
+	case *gltfTintedTexture:
+		w.texture(t.Texture)
+	}
 }
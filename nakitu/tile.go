@@ -0,0 +1,139 @@
+package nakitu
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+type TileOrder int
+
+const (
+	TileOrderScanline TileOrder = iota
+	TileOrderSpiral
+	TileOrderHilbert
+)
+
+func ParseTileOrder(name string) (TileOrder, error) {
+	switch name {
+	case "scanline":
+		return TileOrderScanline, nil
+	case "spiral":
+		return TileOrderSpiral, nil
+	case "hilbert":
+		return TileOrderHilbert, nil
+	}
+	return 0, fmt.Errorf("unknown tile order %q (want scanline, spiral or hilbert)", name)
+}
+
+// Tile is a rectangle of image pixels, [X0, X1) x [Y0, Y1), with the origin
+// at the top left like Image.
+type Tile struct {
+	X0, Y0 int
+	X1, Y1 int
+}
+
+func (t Tile) Pixels() int {
+	return (t.X1 - t.X0) * (t.Y1 - t.Y0)
+}
+
+type TileStat struct {
+	Tile     Tile
+	Pass     int
+	Worker   int
+	Samples  int
+	Duration time.Duration
+}
+
+// Tiles splits the image into TileSize squares in TileOrder.
+func (s *Scene) Tiles() []Tile {
+	size := s.TileSize
+	if size <= 0 {
+		size = 32
+	}
+
+	nx := (s.Width + size - 1) / size
+	ny := (s.Height + size - 1) / size
+
+	tile := func(i, j int) Tile {
+		return Tile{
+			X0: i * size,
+			Y0: j * size,
+			X1: minInt((i+1)*size, s.Width),
+			Y1: minInt((j+1)*size, s.Height),
+		}
+	}
+
+	tiles := make([]Tile, 0, nx*ny)
+	switch s.TileOrder {
+	case TileOrderSpiral:
+		for j := 0; j < ny; j++ {
+			for i := 0; i < nx; i++ {
+				tiles = append(tiles, tile(i, j))
+			}
+		}
+		cx, cy := float64(nx-1)/2, float64(ny-1)/2
+		key := func(t Tile) (float64, float64) {
+			dx := float64(t.X0/size) - cx
+			dy := float64(t.Y0/size) - cy
+			ring := math.Max(math.Abs(dx), math.Abs(dy))
+			return math.Floor(ring + 0.5), math.Atan2(dy, dx)
+		}
+		sort.SliceStable(tiles, func(a, b int) bool {
+			ringA, angleA := key(tiles[a])
+			ringB, angleB := key(tiles[b])
+			if ringA != ringB {
+				return ringA < ringB
+			}
+			return angleA < angleB
+		})
+	case TileOrderHilbert:
+		n := 1
+		for n < nx || n < ny {
+			n *= 2
+		}
+		for d := 0; d < n*n; d++ {
+			i, j := hilbertToXY(n, d)
+			if i < nx && j < ny {
+				tiles = append(tiles, tile(i, j))
+			}
+		}
+	default:
+		for j := 0; j < ny; j++ {
+			for i := 0; i < nx; i++ {
+				tiles = append(tiles, tile(i, j))
+			}
+		}
+	}
+
+	return tiles
+}
+
+// hilbertToXY maps a distance d along a Hilbert curve filling an n x n grid,
+// n a power of two, to grid coordinates.
+func hilbertToXY(n, d int) (int, int) {
+	x, y := 0, 0
+	for s := 1; s < n; s *= 2 {
+		rx := 1 & (d / 2)
+		ry := 1 & (d ^ rx)
+		if ry == 0 {
+			if rx == 1 {
+				x = s - 1 - x
+				y = s - 1 - y
+			}
+			x, y = y, x
+		}
+		x += s * rx
+		y += s * ry
+		d /= 4
+	}
+	return x, y
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
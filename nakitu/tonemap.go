@@ -0,0 +1,127 @@
+package nakitu
+
+import (
+	"fmt"
+	"math"
+)
+
+// ToneMapper maps linear scene radiance to linear display values in [0, 1].
+type ToneMapper interface {
+	Map(c Color) Color
+}
+
+// ParseToneMapper returns the named tone mapper with its default white point.
+func ParseToneMapper(name string) (ToneMapper, error) {
+	switch name {
+	case "linear":
+		return NewLinearToneMapper(), nil
+	case "reinhard":
+		return NewReinhardToneMapper(math.Inf(1)), nil
+	case "aces":
+		return NewACESToneMapper(), nil
+	case "uncharted2":
+		return NewUncharted2ToneMapper(5.6), nil
+	}
+	return nil, fmt.Errorf("unknown tone mapper %q (want linear, reinhard, aces or uncharted2)", name)
+}
+
+type LinearToneMapper struct{}
+
+func NewLinearToneMapper() *LinearToneMapper {
+	return &LinearToneMapper{}
+}
+
+func (l *LinearToneMapper) Map(c Color) Color {
+	return NewVec3(
+		Clamp(c[0], 0, 1),
+		Clamp(c[1], 0, 1),
+		Clamp(c[2], 0, 1),
+	)
+}
+
+// ReinhardToneMapper is the extended Reinhard operator on luminance. Radiance
+// at White maps to 1; an infinite White gives the plain L/(1+L) curve.
+type ReinhardToneMapper struct {
+	White float64
+}
+
+func NewReinhardToneMapper(white float64) *ReinhardToneMapper {
+	return &ReinhardToneMapper{White: white}
+}
+
+func (r *ReinhardToneMapper) Map(c Color) Color {
+	l := Luminance(c)
+	if l <= 0 {
+		return Zero()
+	}
+
+	ld := l * (1 + l/(r.White*r.White)) / (1 + l)
+	return NewLinearToneMapper().Map(c.Mulf(ld / l))
+}
+
+// ACESToneMapper is Krzysztof Narkowicz's fit of the ACES filmic curve.
+type ACESToneMapper struct{}
+
+func NewACESToneMapper() *ACESToneMapper {
+	return &ACESToneMapper{}
+}
+
+func (a *ACESToneMapper) Map(c Color) Color {
+	curve := func(x float64) float64 {
+		x = math.Max(x, 0)
+		return Clamp((x*(2.51*x+0.03))/(x*(2.43*x+0.59)+0.14), 0, 1)
+	}
+
+	return NewVec3(curve(c[0]), curve(c[1]), curve(c[2]))
+}
+
+// Uncharted2ToneMapper is John Hable's filmic curve, normalised so that
+// radiance at White maps to 1.
+type Uncharted2ToneMapper struct {
+	White float64
+}
+
+func NewUncharted2ToneMapper(white float64) *Uncharted2ToneMapper {
+	return &Uncharted2ToneMapper{White: white}
+}
+
+func (u *Uncharted2ToneMapper) Map(c Color) Color {
+	const exposureBias = 2.0
+
+	scale := 1 / uncharted2Curve(u.White*exposureBias)
+	curve := func(x float64) float64 {
+		return Clamp(uncharted2Curve(math.Max(x, 0)*exposureBias)*scale, 0, 1)
+	}
+
+	return NewVec3(curve(c[0]), curve(c[1]), curve(c[2]))
+}
+
+func uncharted2Curve(x float64) float64 {
+	const (
+		a = 0.15
+		b = 0.50
+		c = 0.10
+		d = 0.20
+		e = 0.02
+		f = 0.30
+	)
+	return ((x*(a*x+c*b) + d*e) / (x*(a*x+b) + d*f)) - e/f
+}
+
+func Luminance(c Color) float64 {
+	return 0.2126*c[0] + 0.7152*c[1] + 0.0722*c[2]
+}
+
+func LinearToSRGB(x float64) float64 {
+	if x <= 0.0031308 {
+		return 12.92 * x
+	}
+	return 1.055*math.Pow(x, 1/2.4) - 0.055
+}
+
+func SRGBToLinear(x float64) float64 {
+	if x <= 0.04045 {
+		return x / 12.92
+	}
+	return math.Pow((x+0.055)/1.055, 2.4)
+}
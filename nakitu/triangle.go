@@ -0,0 +1,155 @@
+package nakitu
+
+import "math"
+
+// Triangle is a single triangle. Without vertex normals it is shaded flat;
+// with them the normal is interpolated across the face.
+type Triangle struct {
+	V0, V1, V2    Point3
+	N0, N1, N2    Vec3
+	UV0, UV1, UV2 Vec2
+	Smooth        bool
+	Mat           Material
+}
+
+func NewTriangle(v0, v1, v2 Point3, mat Material) *Triangle {
+	return &Triangle{
+		V0:  v0,
+		V1:  v1,
+		V2:  v2,
+		UV0: NewVec2(0, 0),
+		UV1: NewVec2(1, 0),
+		UV2: NewVec2(0, 1),
+		Mat: mat,
+	}
+}
+
+func NewSmoothTriangle(v0, v1, v2 Point3, n0, n1, n2 Vec3, uv0, uv1, uv2 Vec2, mat Material) *Triangle {
+	return &Triangle{
+		V0:     v0,
+		V1:     v1,
+		V2:     v2,
+		N0:     n0.Unit(),
+		N1:     n1.Unit(),
+		N2:     n2.Unit(),
+		UV0:    uv0,
+		UV1:    uv1,
+		UV2:    uv2,
+		Smooth: true,
+		Mat:    mat,
+	}
+}
+
+func (tr *Triangle) Hit(r *Ray, tMin, tMax float64, rec *HitRecord, rnd *Rand) bool {
+	t, b0, b1, b2, ok := intersectTriangle(r, tr.V0, tr.V1, tr.V2, tMin, tMax)
+	if !ok {
+		return false
+	}
+
+	rec.T = t
+	rec.Point = r.At(t)
+	rec.U = b0*tr.UV0[0] + b1*tr.UV1[0] + b2*tr.UV2[0]
+	rec.V = b0*tr.UV0[1] + b1*tr.UV1[1] + b2*tr.UV2[1]
+
+	geometric := triangleNormal(tr.V0, tr.V1, tr.V2)
+	if tr.Smooth {
+		shading := tr.N0.Mulf(b0).Add(tr.N1.Mulf(b1)).Add(tr.N2.Mulf(b2))
+		rec.SetShadingNormal(r, geometric, shading)
+	} else {
+		rec.SetFaceNormal(r, geometric)
+	}
+	rec.Mat = tr.Mat
+	rec.Mesh = nil
+
+	return true
+}
+
+func (tr *Triangle) BoundingBox(time0, time1 float64, outputBox *AABB) bool {
+	*outputBox = *triangleBox(tr.V0, tr.V1, tr.V2)
+	return true
+}
+
+// intersectTriangle is the watertight ray/triangle test of Woop, Benthin and
+// Wald (2013): rays through shared edges and vertices always hit one of the
+// adjacent triangles. It returns the ray parameter and the barycentric
+// weights of v0, v1 and v2.
+func intersectTriangle(r *Ray, v0, v1, v2 Point3, tMin, tMax float64) (t, b0, b1, b2 float64, ok bool) {
+	kz := 0
+	if math.Abs(r.Dir[1]) > math.Abs(r.Dir[kz]) {
+		kz = 1
+	}
+	if math.Abs(r.Dir[2]) > math.Abs(r.Dir[kz]) {
+		kz = 2
+	}
+	kx := (kz + 1) % 3
+	ky := (kx + 1) % 3
+	if r.Dir[kz] < 0 {
+		kx, ky = ky, kx
+	}
+
+	sx := r.Dir[kx] / r.Dir[kz]
+	sy := r.Dir[ky] / r.Dir[kz]
+	sz := 1 / r.Dir[kz]
+
+	a := v0.Sub(r.Origin)
+	b := v1.Sub(r.Origin)
+	c := v2.Sub(r.Origin)
+
+	ax, ay := a[kx]-sx*a[kz], a[ky]-sy*a[kz]
+	bx, by := b[kx]-sx*b[kz], b[ky]-sy*b[kz]
+	cx, cy := c[kx]-sx*c[kz], c[ky]-sy*c[kz]
+
+	u := cx*by - cy*bx
+	v := ax*cy - ay*cx
+	w := bx*ay - by*ax
+
+	if (u < 0 || v < 0 || w < 0) && (u > 0 || v > 0 || w > 0) {
+		return 0, 0, 0, 0, false
+	}
+
+	det := u + v + w
+	if det == 0 {
+		return 0, 0, 0, 0, false
+	}
+
+	tScaled := u*sz*a[kz] + v*sz*b[kz] + w*sz*c[kz]
+	t = tScaled / det
+	if t <= tMin || t >= tMax {
+		return 0, 0, 0, 0, false
+	}
+
+	invDet := 1 / det
+	return t, u * invDet, v * invDet, w * invDet, true
+}
+
+// triangleNormal is the unit normal of a counter-clockwise triangle. Note that
+// a.Cross(b) computes b x a.
+func triangleNormal(v0, v1, v2 Point3) Vec3 {
+	return v2.Sub(v0).Cross(v1.Sub(v0)).Unit()
+}
+
+func triangleBox(v0, v1, v2 Point3) *AABB {
+	const pad = 0.0001
+
+	min := NewVec3(
+		math.Min(v0[0], math.Min(v1[0], v2[0])),
+		math.Min(v0[1], math.Min(v1[1], v2[1])),
+		math.Min(v0[2], math.Min(v1[2], v2[2])),
+	)
+	max := NewVec3(
+		math.Max(v0[0], math.Max(v1[0], v2[0])),
+		math.Max(v0[1], math.Max(v1[1], v2[1])),
+		math.Max(v0[2], math.Max(v1[2], v2[2])),
+	)
+
+	// a flat box would never be hit, so give axis-aligned triangles the same
+	// thickness as the rectangles
+	for i := 0; i < 3; i++ {
+		if max[i]-min[i] < pad {
+			min[i] -= pad / 2
+			max[i] += pad / 2
+		}
+	}
+
+	return NewAABB(min, max)
+}
@@ -11,6 +11,13 @@ type Vec3 [3]float64
 type Point3 = Vec3
 type Color = Vec3
 
+// Vec2 holds texture coordinates.
+type Vec2 [2]float64
+
+func NewVec2(u, v float64) Vec2 {
+	return Vec2{u, v}
+}
+
 func NewVec3(x, y, z float64) Vec3 {
 	return Vec3{x, y, z}
 }
@@ -35,9 +42,9 @@ func RandomVec3In(min, max float64) Vec3 {
 	)
 }
 
-func RandomInUnitSphere() Vec3 {
+func RandomInUnitSphere(rnd *Rand) Vec3 {
 	for {
-		p := RandomVec3In(-1, 1)
+		p := NewVec3(rnd.Random(-1, 1), rnd.Random(-1, 1), rnd.Random(-1, 1))
 		if p.Len() >= 1 {
 			continue
 		}
@@ -46,12 +53,12 @@ func RandomInUnitSphere() Vec3 {
 	}
 }
 
-func RandomUnitVector() Vec3 {
-	return RandomInUnitSphere().Unit()
+func RandomUnitVector(rnd *Rand) Vec3 {
+	return RandomInUnitSphere(rnd).Unit()
 }
 
-func RandomInHemisphere(normal Vec3) Vec3 {
-	inUnitSphere := RandomInUnitSphere()
+func RandomInHemisphere(normal Vec3, rnd *Rand) Vec3 {
+	inUnitSphere := RandomInUnitSphere(rnd)
 	if inUnitSphere.Dot(normal) > 0 {
 		return inUnitSphere
 	} else {
@@ -59,9 +66,37 @@ func RandomInHemisphere(normal Vec3) Vec3 {
 	}
 }
 
-func RandomInUnitDisk() Vec3 {
+// RandomCosineDirection returns a unit vector around +Z with a density
+// proportional to its Z component.
+func RandomCosineDirection(rnd *Rand) Vec3 {
+	r1, r2 := rnd.Float64(), rnd.Float64()
+	phi := 2 * math.Pi * r1
+	r := math.Sqrt(r2)
+	return NewVec3(math.Cos(phi)*r, math.Sin(phi)*r, math.Sqrt(1-r2))
+}
+
+// ONB is an orthonormal basis whose third axis is a given direction.
+type ONB [3]Vec3
+
+func NewONB(w Vec3) ONB {
+	w = w.Unit()
+	a := NewVec3(1, 0, 0)
+	if math.Abs(w.X()) > 0.9 {
+		a = NewVec3(0, 1, 0)
+	}
+	v := a.Cross(w).Unit()
+	u := v.Cross(w)
+	return ONB{u, v, w}
+}
+
+// Local maps a vector given in the basis to world space.
+func (o ONB) Local(a Vec3) Vec3 {
+	return o[0].Mulf(a[0]).Add(o[1].Mulf(a[1])).Add(o[2].Mulf(a[2]))
+}
+
+func RandomInUnitDisk(rnd *Rand) Vec3 {
 	for {
-		p := NewVec3(Random(-1, 1), Random(-1, 1), 0)
+		p := NewVec3(rnd.Random(-1, 1), rnd.Random(-1, 1), 0)
 		if p.LenSquared() >= 1 {
 			continue
 		}
@@ -0,0 +1,301 @@
+package main
+
+import (
+	"math/rand"
+	"sort"
+
+	. "github.com/arata-nvm/nakitu/nakitu"
+)
+
+type sceneSettings struct {
+	width       int
+	aspectRatio float64
+	background  Color
+	lookFrom    Point3
+	lookAt      Point3
+	vFOV        float64
+}
+
+var builtinScenes = map[string]func() (*Scene, error){
+	"random": func() (*Scene, error) {
+		return newBuiltinScene(randomScene, sceneSettings{
+			width:       400,
+			aspectRatio: 16.0 / 9.0,
+			background:  NewVec3(0.7, 0.8, 1.0),
+			lookFrom:    NewVec3(13, 2, 3),
+			lookAt:      NewVec3(0, 0, 0),
+			vFOV:        20,
+		})
+	},
+	"earth": func() (*Scene, error) {
+		return newBuiltinScene(earth, sceneSettings{
+			width:       400,
+			aspectRatio: 16.0 / 9.0,
+			background:  NewVec3(0.7, 0.8, 1.0),
+			lookFrom:    NewVec3(13, 2, 3),
+			lookAt:      NewVec3(0, 0, 0),
+			vFOV:        20,
+		})
+	},
+	"simple-light": func() (*Scene, error) {
+		return newBuiltinScene(simpleLight, sceneSettings{
+			width:       400,
+			aspectRatio: 16.0 / 9.0,
+			background:  NewVec3(0, 0, 0),
+			lookFrom:    NewVec3(26, 3, 6),
+			lookAt:      NewVec3(0, 2, 0),
+			vFOV:        20,
+		})
+	},
+	"cornell-box": func() (*Scene, error) {
+		return newBuiltinScene(cornellBox, sceneSettings{
+			width:       600,
+			aspectRatio: 1.0,
+			background:  NewVec3(0, 0, 0),
+			lookFrom:    NewVec3(278, 278, -800),
+			lookAt:      NewVec3(278, 278, 0),
+			vFOV:        40,
+		})
+	},
+	"final": func() (*Scene, error) {
+		return newBuiltinScene(finalScene, sceneSettings{
+			width:       800,
+			aspectRatio: 1.0,
+			background:  NewVec3(0, 0, 0),
+			lookFrom:    NewVec3(478, 278, -600),
+			lookAt:      NewVec3(278, 278, 0),
+			vFOV:        40,
+		})
+	},
+}
+
+func builtinSceneNames() []string {
+	names := make([]string, 0, len(builtinScenes))
+	for name := range builtinScenes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func newBuiltinScene(build func() (Hittable, error), settings sceneSettings) (*Scene, error) {
+	world, err := build()
+	if err != nil {
+		return nil, err
+	}
+
+	camera := NewCamera(
+		settings.lookFrom,
+		settings.lookAt,
+		NewVec3(0, 1, 0),
+		settings.vFOV,
+		settings.aspectRatio,
+		0.0,
+		10.0,
+	)
+	camera.Time1 = 1.0
+
+	imageHeight := int(float64(settings.width) / settings.aspectRatio)
+	scene := NewScene(settings.width, imageHeight, world, camera)
+	scene.SamplesPerPixel = 100
+	scene.SamplesPerPass = 10
+	scene.MaxDepth = 50
+	scene.Background = settings.background
+	return scene, nil
+}
+
+func randomScene() (Hittable, error) {
+	world := NewHittableList()
+
+	checker := NewCheckerTexture(
+		NewSolidColor(0.2, 0.3, 0.1),
+		NewSolidColor(0.9, 0.9, 0.9),
+	)
+	matGround := NewLambertian(checker)
+	world.Add(NewSphere(NewVec3(0, -1000, 0), 1000, matGround))
+
+	for a := -11; a < 11; a++ {
+		for b := -11; b < 11; b++ {
+			r := rand.Float64()
+			center := NewVec3(
+				float64(a)+0.9*rand.Float64(),
+				0.2,
+				float64(b)+0.9*rand.Float64(),
+			)
+
+			if center.Sub(NewVec3(4, 0.2, 0)).Len() > 0.9 {
+				switch {
+				case r < 0.8:
+					albedo := RandomVec3().Mul(RandomVec3())
+					mat := NewLambertian(NewSolidColor(albedo.X(), albedo.Y(), albedo.Z()))
+					center2 := center.Add(NewVec3(0, Random(0, 0.5), 0))
+					world.Add(NewMovingSphere(center, center2, 0.0, 1.0, 0.2, mat))
+				case r < 0.95:
+					albedo := RandomVec3In(0.5, 1)
+					fuzz := Random(0, 0.5)
+					mat := NewMetal(albedo, fuzz)
+					world.Add(NewSphere(center, 0.2, mat))
+				default:
+					mat := NewDielectric(1.5)
+					world.Add(NewSphere(center, 0.2, mat))
+				}
+
+			}
+		}
+	}
+
+	mat1 := NewDielectric(1.5)
+	world.Add(NewSphere(NewVec3(0, 1, 0), 1, mat1))
+
+	mat2 := NewLambertian(NewSolidColor(0.4, 0.2, 0.1))
+	world.Add(NewSphere(NewVec3(-4, 1, 0), 1.0, mat2))
+
+	mat3 := NewMetal(NewVec3(0.7, 0.6, 0.5), 0)
+	world.Add(NewSphere(NewVec3(4, 1, 0), 1.0, mat3))
+
+	return world, nil
+}
+
+func earth() (Hittable, error) {
+	world := NewHittableList()
+
+	earthTexture, err := LoadImageTexture("earthmap.jpg")
+	if err != nil {
+		return nil, err
+	}
+	earthSurface := NewLambertian(earthTexture)
+	globe := NewSphere(NewVec3(0, 0, 0), 2, earthSurface)
+	world.Add(globe)
+
+	return world, nil
+}
+
+func simpleLight() (Hittable, error) {
+	world := NewHittableList()
+
+	tex := NewCheckerTexture(
+		NewSolidColor(0.2, 0.3, 0.1),
+		NewSolidColor(0.9, 0.9, 0.9),
+	)
+	world.Add(NewSphere(NewVec3(0, -1000, 0), 1000, NewLambertian(tex)))
+	world.Add(NewSphere(NewVec3(0, 2, 0), 2, NewLambertian(tex)))
+
+	difflight := NewDiffuseLight(NewSolidColor(4, 4, 4))
+	world.Add(NewXYRect(3, 1, 5, 3, -2, difflight))
+
+	return world, nil
+}
+
+func cornellBox() (Hittable, error) {
+	world := NewHittableList()
+
+	red := NewLambertian(NewSolidColor(0.65, 0.05, 0.05))
+	white := NewLambertian(NewSolidColor(0.73, 0.73, 0.73))
+	green := NewLambertian(NewSolidColor(0.12, 0.45, 0.15))
+	light := NewDiffuseLight(NewSolidColor(15, 15, 15))
+
+	world.Add(NewYZRect(0, 0, 555, 555, 555, green))
+	world.Add(NewYZRect(0, 0, 555, 555, 0, red))
+	world.Add(NewXZRect(213, 227, 343, 332, 554, light))
+	world.Add(NewXZRect(0, 0, 555, 555, 0, white))
+	world.Add(NewXZRect(0, 0, 555, 555, 555, white))
+	world.Add(NewXYRect(0, 0, 555, 555, 555, white))
+
+	box1, err := NewTransform(
+		NewBox(NewVec3(0, 0, 0), NewVec3(165, 330, 165), white),
+		Compose(NewRotation(NewVec3(0, 1, 0), 15), NewTranslation(NewVec3(265, 0, 295))),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	box2, err := NewTransform(
+		NewBox(NewVec3(0, 0, 0), NewVec3(165, 165, 165), white),
+		Compose(NewRotation(NewVec3(0, 1, 0), -18), NewTranslation(NewVec3(130, 0, 65))),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	world.Add(NewConstantMedium(box1, 0.01, NewSolidColor(0, 0, 0)))
+	world.Add(NewConstantMedium(box2, 0.01, NewSolidColor(1, 1, 1)))
+
+	return world, nil
+}
+
+func finalScene() (Hittable, error) {
+	boxes1 := NewHittableList()
+
+	matGround := NewLambertian(NewSolidColor(0.48, 0.83, 0.53))
+	unitBox := NewBox(NewVec3(0, 0, 0), NewVec3(1, 1, 1), matGround)
+
+	boxesPerSide := 20
+	for i := 0; i < boxesPerSide; i++ {
+		for j := 0; j < boxesPerSide; j++ {
+			w := 100.0
+			x0 := -1000.0 + float64(i)*w
+			x1 := x0 + w
+
+			z0 := -1000.0 + float64(j)*w
+			z1 := z0 + w
+
+			y0 := 0.0
+			y1 := Random(0, 101)
+
+			box, err := NewInstance(unitBox, Compose(
+				NewScale(NewVec3(x1-x0, y1-y0, z1-z0)),
+				NewTranslation(NewVec3(x0, y0, z0)),
+			), nil)
+			if err != nil {
+				return nil, err
+			}
+			boxes1.Add(box)
+		}
+	}
+
+	world := NewHittableList()
+
+	world.Add(NewBVHNode(boxes1, 0, 1))
+
+	light := NewDiffuseLight(NewSolidColor(7, 7, 7))
+	world.Add(NewXZRect(123, 147, 423, 412, 554, light))
+
+	center1 := NewVec3(400, 400, 200)
+	center2 := center1.Add(NewVec3(30, 0, 0))
+	matMovingSphere := NewLambertian(NewSolidColor(0.7, 0.3, 0.1))
+	world.Add(NewMovingSphere(center1, center2, 0, 1, 50, matMovingSphere))
+
+	world.Add(NewSphere(NewVec3(260, 150, 45), 50, NewDielectric(1.5)))
+	world.Add(NewSphere(NewVec3(0, 150, 145), 50, NewMetal(NewVec3(0.8, 0.8, 0.9), 1.0)))
+
+	boundary := NewSphere(NewVec3(360, 150, 145), 70, NewDielectric(1.5))
+	world.Add(boundary)
+	world.Add(NewConstantMedium(boundary, 0.2, NewSolidColor(0.2, 0.4, 0.9)))
+	boundary = NewSphere(NewVec3(0, 0, 0), 5000, NewDielectric(1.5))
+	world.Add(NewConstantMedium(boundary, 0.0001, NewSolidColor(1, 1, 1)))
+
+	texEarth, err := LoadImageTexture("earthmap.jpg")
+	if err != nil {
+		return nil, err
+	}
+	world.Add(NewSphere(NewVec3(400, 200, 400), 100, NewLambertian(texEarth)))
+	texPerlin := NewMarbleTexture(NewPerlin(rand.Uint64()), 0.1)
+	world.Add(NewSphere(NewVec3(220, 280, 300), 80, NewLambertian(texPerlin)))
+
+	boxes2 := NewHittableList()
+	white := NewLambertian(NewSolidColor(0.73, 0.73, 0.73))
+	sphere := NewSphere(NewVec3(0, 0, 0), 10, white)
+	cluster := Compose(NewRotation(NewVec3(0, 1, 0), 15), NewTranslation(NewVec3(-100, 270, 395)))
+	ns := 1000
+	for j := 0; j < ns; j++ {
+		instance, err := NewInstance(sphere, Compose(NewTranslation(RandomVec3In(0, 165)), cluster), nil)
+		if err != nil {
+			return nil, err
+		}
+		boxes2.Add(instance)
+	}
+
+	world.Add(NewBVHNode(boxes2, 0, 1))
+
+	return world, nil
+}